@@ -0,0 +1,590 @@
+package gomark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Snapshot is an archived copy of the page of a bookmark. Html and Text
+// are the names of the files in the archive, derived from their content.
+type Snapshot struct {
+	Date time.Time
+	// URL reached after the redirects
+	Url  string
+	Html string
+	Text string
+}
+
+// Default maximum size of a stylesheet, image or font inlined in a snapshot
+const DefaultMaxResourceSize = 5 << 20
+
+// Default maximum number of resources fetched for a snapshot
+const DefaultMaxResources = 200
+
+// Default maximum size of the resources inlined in a snapshot, their
+// fetched size and their encoded size are both limited
+const DefaultMaxInlinedSize = 50 << 20
+
+// Depth of the stylesheets imported by other stylesheets that are inlined
+const maxImportDepth = 3
+
+// Archiver stores self-contained snapshots of pages in Dir: the HTML with
+// the stylesheets and images inlined, without scripts, and the text of the
+// main content. Files are named after the SHA-256 of their content so a
+// page that did not change is stored once.
+type Archiver struct {
+	Dir string
+	// Makes the requests, DefaultFetcher when nil
+	Fetcher         *Fetcher
+	MaxResourceSize int64
+	// The resources past these limits keep their absolute URL, zero means
+	// no limit
+	MaxResources   int
+	MaxInlinedSize int64
+}
+
+func NewArchiver(dir string, fetcher *Fetcher) (*Archiver, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Archiver{
+		Dir:             dir,
+		Fetcher:         fetcher,
+		MaxResourceSize: DefaultMaxResourceSize,
+		MaxResources:    DefaultMaxResources,
+		MaxInlinedSize:  DefaultMaxInlinedSize,
+	}, nil
+}
+
+// Archive fetches the page of b and stores its snapshot, b is left
+// untouched
+func (a *Archiver) Archive(b *Bookmark) (snapshot Snapshot, err error) {
+
+	rawUrl := b.RawUrl
+	if len(rawUrl) == 0 {
+		rawUrl = b.GetURL()
+	}
+
+	res, err := a.Fetcher.Get(rawUrl, "text/html")
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return snapshot, fmt.Errorf("Error while archiving %s: %s", rawUrl, res.Status)
+	}
+
+	r, err := charset.NewReader(res.Body, res.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return
+	}
+
+	base := res.Request.URL
+	text := ExtractText(doc)
+
+	inliner := resourceInliner{archiver: a, cache: make(map[string]*fetchedResource)}
+	inliner.document(doc, base)
+
+	var page bytes.Buffer
+	if err = html.Render(&page, doc); err != nil {
+		return
+	}
+
+	snapshot = Snapshot{Date: time.Now(), Url: base.String()}
+
+	if snapshot.Html, err = a.store(page.Bytes(), ".html"); err != nil {
+		return
+	}
+
+	snapshot.Text, err = a.store([]byte(text), ".txt")
+
+	return
+}
+
+// ArchiveBookmark archives the page of the bookmark with the given ID and
+// adds the snapshot to it
+func (a *Archiver) ArchiveBookmark(store Store, id string) (*Bookmark, error) {
+
+	b, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := a.Archive(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Update(id, func(b *Bookmark) error {
+		b.Snapshots = append(b.Snapshots, snapshot)
+		return nil
+	})
+}
+
+// Text returns the text of the main content of the snapshot
+func (a *Archiver) Text(snapshot Snapshot) (string, error) {
+
+	if !snapshotName.MatchString(snapshot.Text) {
+		return "", fmt.Errorf("Invalid snapshot name %q", snapshot.Text)
+	}
+
+	text, err := ioutil.ReadFile(a.path(snapshot.Text))
+	return string(text), err
+}
+
+// store writes data under the name of its hash and returns the name
+func (a *Archiver) store(data []byte, ext string) (string, error) {
+
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + ext
+
+	filename := a.path(name)
+
+	if _, err := os.Stat(filename); err == nil {
+		return name, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return "", err
+	}
+
+	return name, writeFileAtomic(filename, data, 0)
+}
+
+// Files are spread in subdirectories named after the first two characters
+// of their name
+func (a *Archiver) path(name string) string {
+	return filepath.Join(a.Dir, name[:2], name)
+}
+
+var snapshotName = regexp.MustCompile(`^[0-9a-f]{64}\.(html|txt)$`)
+
+// Handler serves the files of the snapshots, as /<name>. With an
+// authenticator the requests need HTTP basic authentication.
+func (a *Archiver) Handler(authenticator Authenticator) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if authenticator != nil {
+			username, password, ok := r.BasicAuth()
+			if !ok || !authenticator.CheckCredentials(username, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gomark"`)
+				http.Error(w, "Access Denied", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if !snapshotName.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		data, err := ioutil.ReadFile(a.path(name))
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The snapshots come from other sites, nothing in them may run or
+		// load anything
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; font-src data:; sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		if strings.HasSuffix(name, ".txt") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+
+		w.Write(data)
+	})
+}
+
+// resourceInliner replaces the links of a page to its stylesheets, images
+// and fonts by data URLs
+type resourceInliner struct {
+	archiver *Archiver
+	// Resources already fetched, or failed, by URL
+	cache map[string]*fetchedResource
+	// Number of resources fetched, and bytes fetched and inlined, checked
+	// against the limits of the archiver
+	fetched     int
+	fetchedSize int64
+	inlinedSize int64
+}
+
+type fetchedResource struct {
+	data        []byte
+	contentType string
+	err         error
+}
+
+// Elements removed from the snapshots, they either run code or load
+// content that cannot be inlined
+var strippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Noscript: true, atom.Iframe: true, atom.Frame: true,
+	atom.Frameset: true, atom.Object: true, atom.Embed: true, atom.Applet: true,
+	atom.Base: true,
+}
+
+func (in *resourceInliner) document(doc *html.Node, base *url.URL) {
+
+	// The links are relative to the base element when there is one
+	if b := findElement(doc, atom.Base); b != nil {
+		if ref, err := url.Parse(attr(b, "href")); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	in.node(doc, base)
+
+	// The snapshot is UTF-8 whatever the page was
+	if head := findElement(doc, atom.Head); head != nil {
+		head.InsertBefore(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "meta",
+			DataAtom: atom.Meta,
+			Attr:     []html.Attribute{{Key: "charset", Val: "utf-8"}},
+		}, head.FirstChild)
+	}
+}
+
+func (in *resourceInliner) node(n *html.Node, base *url.URL) {
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		if c.Type == html.ElementNode && (strippedElements[c.DataAtom] || isCharsetMeta(c)) {
+			n.RemoveChild(c)
+		} else {
+			in.node(c, base)
+		}
+
+		c = next
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	// Event handlers and javascript: links
+	var attrs []html.Attribute
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if strings.HasPrefix(key, "on") || key == "srcset" ||
+			strings.HasPrefix(strings.ToLower(strings.TrimSpace(a.Val)), "javascript:") {
+			continue
+		}
+
+		if key == "style" {
+			a.Val = in.css(a.Val, base, 0)
+		}
+
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+
+	switch n.DataAtom {
+
+	case atom.Img, atom.Input:
+		if src := attr(n, "src"); len(src) > 0 {
+			setAttr(n, "src", in.resource(src, base))
+		}
+
+	case atom.Video, atom.Audio:
+		if poster := attr(n, "poster"); len(poster) > 0 {
+			setAttr(n, "poster", in.resource(poster, base))
+		}
+
+	case atom.Style:
+		if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+			n.FirstChild.Data = in.css(n.FirstChild.Data, base, 0)
+		}
+
+	case atom.Link:
+		rel := strings.Fields(strings.ToLower(attr(n, "rel")))
+		href := attr(n, "href")
+
+		for _, r := range rel {
+			switch r {
+			case "stylesheet":
+				in.stylesheet(n, href, base)
+				return
+			case "icon", "apple-touch-icon":
+				setAttr(n, "href", in.resource(href, base))
+				return
+			}
+		}
+
+	case atom.A, atom.Area:
+		// Links lead back to the live site
+		if href := attr(n, "href"); len(href) > 0 && !strings.HasPrefix(href, "#") {
+			if ref, err := url.Parse(href); err == nil {
+				setAttr(n, "href", base.ResolveReference(ref).String())
+			}
+		}
+	}
+}
+
+// stylesheet turns a link to a stylesheet into a style element
+func (in *resourceInliner) stylesheet(n *html.Node, href string, base *url.URL) {
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return
+	}
+
+	u := base.ResolveReference(ref)
+
+	data, _, err := in.fetch(u)
+	if err == nil && !in.reserve(len(data)) {
+		err = errInlinedSize
+	}
+
+	if err != nil {
+		setAttr(n, "href", u.String())
+		return
+	}
+
+	media := attr(n, "media")
+
+	n.Data = "style"
+	n.DataAtom = atom.Style
+	n.Attr = nil
+	if len(media) > 0 {
+		n.Attr = []html.Attribute{{Key: "media", Val: media}}
+	}
+
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: in.css(string(data), u, 0)})
+}
+
+var (
+	cssURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*?))\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// css inlines the resources of a stylesheet located at base, the imported
+// stylesheets are inlined up to maxImportDepth
+func (in *resourceInliner) css(css string, base *url.URL, depth int) string {
+
+	css = cssImport.ReplaceAllString(css, "@import url(\"$1$2\")")
+
+	return cssURL.ReplaceAllStringFunc(css, func(match string) string {
+
+		groups := cssURL.FindStringSubmatch(match)
+		ref := groups[1] + groups[2] + groups[3]
+
+		if len(ref) == 0 || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			return match
+		}
+
+		parsed, err := url.Parse(ref)
+		if err != nil {
+			return match
+		}
+
+		u := base.ResolveReference(parsed)
+
+		data, contentType, err := in.fetch(u)
+		if err != nil {
+			return fmt.Sprintf("url(%q)", u.String())
+		}
+
+		if strings.HasPrefix(contentType, "text/css") {
+			if depth >= maxImportDepth {
+				return fmt.Sprintf("url(%q)", u.String())
+			}
+			data = []byte(in.css(string(data), u, depth+1))
+		}
+
+		inlined := dataURL(contentType, data)
+		if !in.reserve(len(inlined)) {
+			return fmt.Sprintf("url(%q)", u.String())
+		}
+
+		return fmt.Sprintf("url(%q)", inlined)
+	})
+}
+
+// resource returns the data URL of the resource at ref, or its absolute
+// URL if it cannot be fetched
+func (in *resourceInliner) resource(ref string, base *url.URL) string {
+
+	if strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+
+	u := base.ResolveReference(parsed)
+
+	data, contentType, err := in.fetch(u)
+	if err != nil {
+		return u.String()
+	}
+
+	inlined := dataURL(contentType, data)
+	if !in.reserve(len(inlined)) {
+		return u.String()
+	}
+
+	return inlined
+}
+
+func (in *resourceInliner) fetch(u *url.URL) ([]byte, string, error) {
+
+	key := u.String()
+
+	r, ok := in.cache[key]
+	if !ok {
+		r = in.get(key)
+		in.cache[key] = r
+	}
+
+	return r.data, r.contentType, r.err
+}
+
+var (
+	errTooManyResources = errors.New("Too many resources in the snapshot")
+	errInlinedSize      = errors.New("Too many bytes inlined in the snapshot")
+)
+
+// reserve accounts for size bytes inlined in the snapshot, it returns false
+// when they do not fit
+func (in *resourceInliner) reserve(size int) bool {
+
+	max := in.archiver.MaxInlinedSize
+	if max > 0 && in.inlinedSize+int64(size) > max {
+		return false
+	}
+
+	in.inlinedSize += int64(size)
+	return true
+}
+
+func (in *resourceInliner) get(rawUrl string) *fetchedResource {
+
+	if max := in.archiver.MaxResources; max > 0 && in.fetched >= max {
+		return &fetchedResource{err: errTooManyResources}
+	}
+
+	if max := in.archiver.MaxInlinedSize; max > 0 && in.fetchedSize >= max {
+		return &fetchedResource{err: errInlinedSize}
+	}
+
+	in.fetched++
+
+	f := *in.archiver.Fetcher.orDefault()
+	if in.archiver.MaxResourceSize > 0 {
+		f.MaxBodySize = in.archiver.MaxResourceSize + 1
+	}
+
+	res, err := f.Get(rawUrl, "")
+	if err != nil {
+		return &fetchedResource{err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &fetchedResource{err: fmt.Errorf("Error while getting %s: %s", rawUrl, res.Status)}
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	in.fetchedSize += int64(len(data))
+	if err != nil {
+		return &fetchedResource{err: err}
+	}
+
+	if in.archiver.MaxResourceSize > 0 && int64(len(data)) > in.archiver.MaxResourceSize {
+		return &fetchedResource{err: fmt.Errorf("Resource %s larger than %v bytes", rawUrl, in.archiver.MaxResourceSize)}
+	}
+
+	contentType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if len(contentType) == 0 || contentType == "application/octet-stream" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+
+	return &fetchedResource{data: data, contentType: contentType}
+}
+
+func dataURL(contentType string, data []byte) string {
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func isCharsetMeta(n *html.Node) bool {
+	return n.DataAtom == atom.Meta &&
+		(len(attr(n, "charset")) > 0 || strings.EqualFold(attr(n, "http-equiv"), "content-type"))
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func setAttr(n *html.Node, key string, value string) {
+
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
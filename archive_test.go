@@ -0,0 +1,251 @@
+package gomark_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArchiver(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		fmt.Fprint(w, "<html><head><meta charset=\"iso-8859-1\"><title>Caf\xe9 page</title>"+
+			`<link rel="stylesheet" href="/style.css">
+			<script src="/app.js"></script><script>alert(1)</script>
+			</head><body onload="alert(2)">
+			<nav><a href="/">Home</a></nav>
+			<article class="post"><h1>Caf`+"\xe9"+` page</h1>
+			<p>This is the first paragraph of the article, long enough to count.</p>
+			<p style="background: url('/dot.png')">Second paragraph, with a background, a comma and more text.</p>
+			<img src="/dot.png" srcset="/big.png 2x"><img src="/missing.png">
+			<a href="javascript:alert(3)">Run</a> <a href="/other">Other</a>
+			</article>
+			<iframe src="https://example.com/ad"></iframe>
+			<footer>Copyright</footer></body></html>`)
+	})
+
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		fmt.Fprint(w, `@import "more.css"; body { background: url(dot.png) }`)
+	})
+
+	mux.HandleFunc("/more.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		fmt.Fprint(w, `h1 { color: red }`)
+	})
+
+	mux.HandleFunc("/dot.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archiver, err := gomark.NewArchiver(dir, testFetcher())
+	if err != nil {
+		t.Fatalf("Error while creating the archiver: %v", err)
+	}
+
+	// The text of the snapshots is indexed
+	db := gomark.NewIndexedStore(gomark.NewDatabase())
+	db.Archiver = archiver
+
+	b, _ := gomark.NewBookmarkRawUrl(ts.URL + "/page")
+	db.Put(b)
+
+	b, err = archiver.ArchiveBookmark(db, b.ID)
+	if err != nil {
+		t.Fatalf("Error while archiving: %v", err)
+	}
+
+	if len(b.Snapshots) != 1 {
+		t.Fatalf("Error in the snapshots: got %v", b.Snapshots)
+	}
+
+	snapshot := b.Snapshots[0]
+
+	stored, _ := db.Get(b.ID)
+	if len(stored.Snapshots) != 1 || stored.Snapshots[0] != snapshot {
+		t.Errorf("Error in the stored snapshots: got %v", stored.Snapshots)
+	}
+
+	if hits, _ := db.Search("background", 10); len(hits) != 1 || !strings.Contains(hits[0].Snippet, "count. Second paragraph, with a <mark>background</mark>, a comma") {
+		t.Errorf("Error while searching the snapshot: got %v", hits)
+	}
+
+	files := httptest.NewServer(archiver.Handler(testAuthenticator{}))
+	defer files.Close()
+
+	get := func(name string, auth bool) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", files.URL+"/"+name, nil)
+		if auth {
+			req.SetBasicAuth("user", "secret")
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error while getting %s: %v", name, err)
+		}
+		defer res.Body.Close()
+
+		body, _ := ioutil.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, page := get(snapshot.Html, true)
+
+	if res.StatusCode != 200 || !strings.Contains(res.Header.Get("Content-Security-Policy"), "default-src 'none'") {
+		t.Errorf("Error while serving the snapshot: %v %v", res.Status, res.Header)
+	}
+
+	for _, expected := range []string{
+		`<meta charset="utf-8"/>`,
+		"Café page",
+		"<style>@import url(\"data:text/css;base64,",
+		`url("data:image/png;base64,iVBORw0KGgo=")`,
+		`<img src="data:image/png;base64,iVBORw0KGgo="/>`,
+		`<img src="` + ts.URL + `/missing.png"/>`,
+		`<a href="` + ts.URL + `/other">`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Missing %s in the snapshot", expected)
+		}
+	}
+
+	for _, forbidden := range []string{"<script", "alert", "onload", "srcset", "<iframe", "iso-8859-1", "/style.css"} {
+		if strings.Contains(page, forbidden) {
+			t.Errorf("Found %s in the snapshot", forbidden)
+		}
+	}
+
+	_, text := get(snapshot.Text, true)
+
+	expected := "Café page\n\nThis is the first paragraph of the article, long enough to count." +
+		"\n\nSecond paragraph, with a background, a comma and more text.\n\nRun Other"
+	if text != expected {
+		t.Errorf("Error in the text of the snapshot: got %q", text)
+	}
+
+	// Unchanged pages are stored once
+	b, _ = archiver.ArchiveBookmark(db, b.ID)
+	if len(b.Snapshots) != 2 || b.Snapshots[1].Html != snapshot.Html {
+		t.Errorf("Error in the second snapshot: got %v", b.Snapshots)
+	}
+
+	if res, _ := get(snapshot.Html, false); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Snapshot served without authentication: %v", res.Status)
+	}
+
+	for _, name := range []string{"../archive_test.go", strings.Repeat("0", 64) + ".html", "index.html"} {
+		if res, _ := get(name, true); res.StatusCode != http.StatusNotFound {
+			t.Errorf("Error while getting %s: got %v", name, res.Status)
+		}
+	}
+
+	b, _ = gomark.NewBookmarkRawUrl(ts.URL + "/gone")
+	db.Put(b)
+
+	if _, err := archiver.ArchiveBookmark(db, b.ID); err == nil {
+		t.Errorf("No error while archiving a missing page")
+	}
+}
+
+func TestArchiverLimits(t *testing.T) {
+
+	var fetched int32
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body>")
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(w, `<img src="/image%d.png"><img src="/image0.png">`, i)
+		}
+		fmt.Fprint(w, "</body></html>")
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0}, 100))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archiver, err := gomark.NewArchiver(dir, testFetcher())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := gomark.NewBookmarkRawUrl(ts.URL + "/page")
+
+	archive := func() string {
+		snapshot, err := archiver.Archive(b)
+		if err != nil {
+			t.Fatalf("Error while archiving: %v", err)
+		}
+
+		page, _ := ioutil.ReadFile(filepath.Join(dir, snapshot.Html[:2], snapshot.Html))
+		return string(page)
+	}
+
+	// The other resources keep their URL
+	archiver.MaxResources = 3
+
+	page := archive()
+	if n := atomic.LoadInt32(&fetched); n != 3 {
+		t.Errorf("Error in the resource limit: %v resources fetched", n)
+	}
+
+	// The first image is used 11 times
+	if n := strings.Count(page, `src="data:`); n != 13 {
+		t.Errorf("Error in the resource limit: %v resources inlined", n)
+	}
+
+	if !strings.Contains(page, `src="`+ts.URL+`/image3.png"`) {
+		t.Errorf("Resource past the limit not kept as a link: %s", page)
+	}
+
+	// Each use of a resource counts, 158 bytes for each data URL
+	archiver.MaxResources = 0
+	archiver.MaxInlinedSize = 1000
+
+	page = archive()
+	if n := strings.Count(page, `src="data:`); n != 6 {
+		t.Errorf("Error in the size limit: %v resources inlined", n)
+	}
+
+	// The fetched bytes count too, even when not inlined
+	atomic.StoreInt32(&fetched, 0)
+	archiver.MaxInlinedSize = 250
+
+	archive()
+	if n := atomic.LoadInt32(&fetched); n != 3 {
+		t.Errorf("Error in the size limit: %v resources fetched", n)
+	}
+}
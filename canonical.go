@@ -0,0 +1,143 @@
+package gomark
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Canonicalizer rewrites URLs so the different spellings of a page end up
+// in the same bookmark
+type Canonicalizer struct {
+	// Rewrite http to https when no port is given
+	UpgradeScheme      bool
+	StripTrailingSlash bool
+	LowercaseHost      bool
+	StripDefaultPort   bool
+	SortQuery          bool
+	// Query parameters removed from the URL, a trailing * matches any suffix
+	StripParams []string
+}
+
+var DefaultCanonicalizer = Canonicalizer{
+	UpgradeScheme:      true,
+	StripTrailingSlash: true,
+	LowercaseHost:      true,
+	StripDefaultPort:   true,
+	SortQuery:          true,
+	StripParams: []string{
+		"utm_*", "fbclid", "gclid", "dclid", "msclkid", "yclid",
+		"mc_cid", "mc_eid", "igshid", "_hsenc", "_hsmi",
+	},
+}
+
+// Canonicalizer used for every bookmark URL
+var URLCanonicalizer = DefaultCanonicalizer
+
+// Canonicalize returns the canonical form of u, u is left untouched
+func (c *Canonicalizer) Canonicalize(u *url.URL) *url.URL {
+
+	canonical := *u
+
+	if c.LowercaseHost {
+		canonical.Host = strings.ToLower(canonical.Host)
+	}
+
+	port := canonical.Port()
+
+	if c.StripDefaultPort && isDefaultPort(canonical.Scheme, port) {
+		canonical.Host = joinHost(canonical.Hostname(), "")
+		port = ""
+	}
+
+	if c.UpgradeScheme && canonical.Scheme == "http" && len(port) == 0 {
+		canonical.Scheme = "https"
+	}
+
+	if c.StripTrailingSlash && strings.HasSuffix(canonical.Path, "/") {
+		canonical.Path = strings.TrimRight(canonical.Path, "/")
+		canonical.RawPath = strings.TrimRight(canonical.RawPath, "/")
+	}
+
+	canonical.RawQuery = c.canonicalQuery(canonical.RawQuery)
+	canonical.ForceQuery = false
+
+	return &canonical
+}
+
+// The query is filtered on its raw form so the parameters that are kept
+// are not re-encoded
+func (c *Canonicalizer) canonicalQuery(rawQuery string) string {
+
+	var pairs []string
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if len(pair) == 0 {
+			continue
+		}
+
+		name := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if !c.stripped(name) {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	if c.SortQuery {
+		sort.Strings(pairs)
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func (c *Canonicalizer) stripped(param string) bool {
+
+	param = strings.ToLower(param)
+
+	for _, pattern := range c.StripParams {
+		pattern = strings.ToLower(pattern)
+
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(param, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if param == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDefaultPort(scheme string, port string) bool {
+	return (scheme == "http" && port == "80") || (scheme == "https" && port == "443")
+}
+
+func joinHost(host string, port string) string {
+
+	if len(port) > 0 {
+		return net.JoinHostPort(host, port)
+	}
+
+	// IPv6 addresses keep their brackets
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
+
+// CanonicalURL parses rawUrl and returns its canonical form
+func CanonicalURL(rawUrl string) (string, error) {
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	return URLCanonicalizer.Canonicalize(u).String(), nil
+}
@@ -0,0 +1,51 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+
+	c := gomark.DefaultCanonicalizer
+
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{"http://example.com", "https://example.com"},
+		{"https://example.com/", "https://example.com"},
+		{"https://example.com/?utm_source=x", "https://example.com"},
+		{"HTTPS://Example.COM:443/Path/", "https://example.com/Path"},
+		{"http://example.com:80/a", "https://example.com/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com/a?b=2&a=1&fbclid=x&UTM_medium=y", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/a?q=hello%20world&gclid=1#section", "https://example.com/a?q=hello%20world#section"},
+		{"http://[::1]:80/", "https://[::1]"},
+	}
+
+	for _, tc := range cases {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := c.Canonicalize(u).String()
+		if got != tc.expected {
+			t.Errorf("Error canonicalizing %s: got %s expected %s", tc.in, got, tc.expected)
+		}
+
+		if u.String() == got && tc.in != tc.expected {
+			t.Errorf("Canonicalize modified its argument %s", tc.in)
+		}
+	}
+
+	// Everything disabled
+	var none gomark.Canonicalizer
+	u, _ := url.Parse("http://Example.com:80/a/?utm_source=x&b=1")
+	if got := none.Canonicalize(u).String(); got != "http://Example.com:80/a/?utm_source=x&b=1" {
+		t.Errorf("Disabled canonicalizer changed the URL: %s", got)
+	}
+
+}
@@ -0,0 +1,138 @@
+package gomark
+
+import (
+	"log"
+	"sync"
+)
+
+// Default number of pages fetched at the same time by the server
+const DefaultEnrichWorkers = 4
+
+// Default number of bookmarks waiting for their page to be fetched
+const DefaultEnrichQueue = 256
+
+// Enricher fetches the pages of new bookmarks in the background, with a
+// bounded number of workers, and stores their title, description and
+// preview. An "updated" event is published for every enriched bookmark.
+type Enricher struct {
+	// Archives the new bookmarks too when set
+	Archiver *Archiver
+
+	store   Store
+	fetcher *Fetcher
+	events  *EventHub
+	queue   chan string
+	wg      sync.WaitGroup
+}
+
+// NewEnricher starts workers reading a queue of queueSize bookmark IDs,
+// the pages are fetched with fetcher, DefaultFetcher when nil, and events
+// can be nil
+func NewEnricher(store Store, fetcher *Fetcher, events *EventHub, workers int, queueSize int) *Enricher {
+
+	e := &Enricher{
+		store:   store,
+		fetcher: fetcher,
+		events:  events,
+		queue:   make(chan string, queueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+
+	return e
+}
+
+// Enqueue schedules the enrichment of the bookmark with the given ID. It
+// returns false, without waiting, when the queue is full.
+func (e *Enricher) Enqueue(id string) bool {
+
+	select {
+	case e.queue <- id:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close waits for the queued bookmarks to be enriched and stops the
+// workers, Enqueue must not be called anymore
+func (e *Enricher) Close() {
+	close(e.queue)
+	e.wg.Wait()
+}
+
+func (e *Enricher) work() {
+
+	defer e.wg.Done()
+
+	for id := range e.queue {
+		e.enrich(id)
+
+		if e.Archiver != nil {
+			e.archive(id)
+		}
+	}
+}
+
+func (e *Enricher) enrich(id string) {
+
+	b, err := e.store.Get(id)
+	if err != nil {
+		// Deleted before its turn
+		return
+	}
+
+	rawUrl := b.RawUrl
+
+	page, err := b.fetchPage(e.fetcher)
+	if err != nil {
+		log.Printf("Impossible to retrieve title for url %s: %v", rawUrl, err)
+		return
+	}
+
+	// Only the page information is set, the bookmark may have changed
+	// during the fetch
+	b, err = e.store.Update(id, func(b *Bookmark) error {
+		if b.RawUrl != rawUrl {
+			return errURLChanged
+		}
+		b.SetPage(page)
+		return nil
+	})
+
+	if err == errURLChanged {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Impossible to store the page information of %s: %v", rawUrl, err)
+		return
+	}
+
+	e.publish(b)
+}
+
+func (e *Enricher) archive(id string) {
+
+	b, err := e.Archiver.ArchiveBookmark(e.store, id)
+	if err != nil {
+		log.Printf("Impossible to archive bookmark %s: %v", id, err)
+		return
+	}
+
+	e.publish(b)
+}
+
+func (e *Enricher) publish(b *Bookmark) {
+
+	if e.events != nil {
+		e.events.Publish(Event{
+			Type:     "updated",
+			DataType: "bookmark",
+			Result:   map[string]Bookmark{b.ID: *b},
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnricher(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Page %s</title>
+		<meta name="description" content="Fetched description"></head></html>`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	db := gomark.NewDatabase()
+	events := gomark.NewEventHub()
+
+	updates, cancel := events.Subscribe()
+	defer cancel()
+
+	enricher := gomark.NewEnricher(db, testFetcher(), events, 2, 10)
+
+	a, _ := gomark.NewBookmarkRawUrl(ts.URL + "/a")
+	b, _ := gomark.NewBookmarkRawUrl(ts.URL + "/b")
+	b.Description = "Set by the user"
+
+	for _, book := range []*gomark.Bookmark{a, b} {
+		if book.Title != book.RawUrl {
+			t.Errorf("Error in the placeholder title: got %s", book.Title)
+		}
+
+		db.Put(book)
+		if !enricher.Enqueue(book.ID) {
+			t.Errorf("Error while enqueuing %s", book.RawUrl)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-updates:
+			if e.Type != "updated" || e.DataType != "bookmark" || len(e.Result) != 1 {
+				t.Errorf("Error in the event: got %v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No event after the enrichment")
+		}
+	}
+
+	enricher.Close()
+
+	a, _ = db.Get(a.ID)
+	if a.Title != "Page /a" || a.Description != "Fetched description" {
+		t.Errorf("Error in the enrichment: got %s, %s", a.Title, a.Description)
+	}
+
+	b, _ = db.Get(b.ID)
+	if b.Title != "Page /b" || b.Description != "Set by the user" {
+		t.Errorf("Error in the enrichment: got %s, %s", b.Title, b.Description)
+	}
+}
+
+func TestEnricherQueue(t *testing.T) {
+
+	fetching := make(chan bool)
+	release := make(chan bool)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetching <- true
+		<-release
+		fmt.Fprint(w, `<html><head><title>Slow</title></head></html>`)
+	}))
+	defer ts.Close()
+
+	db := gomark.NewDatabase()
+	enricher := gomark.NewEnricher(db, testFetcher(), nil, 1, 1)
+
+	var ids []string
+	for _, path := range []string{"/a", "/b", "/c"} {
+		b, _ := gomark.NewBookmarkRawUrl(ts.URL + path)
+		db.Put(b)
+		ids = append(ids, b.ID)
+	}
+
+	// The single worker fetches the first page, the second one waits in the
+	// queue and the third one is refused
+	enricher.Enqueue(ids[0])
+	<-fetching
+
+	if !enricher.Enqueue(ids[1]) {
+		t.Errorf("Error while enqueuing in a free queue")
+	}
+
+	if enricher.Enqueue(ids[2]) {
+		t.Errorf("Error while enqueuing in a full queue: accepted")
+	}
+
+	release <- true
+	<-fetching
+	release <- true
+
+	// A bookmark deleted while queued is skipped
+	db.Delete(ids[0])
+	enricher.Enqueue(ids[0])
+
+	enricher.Close()
+
+	for i, title := range []string{"Slow", ts.URL + "/c"} {
+		b, _ := db.Get(ids[i+1])
+		if b.Title != title {
+			t.Errorf("Error in the title of %s: got %s", b.RawUrl, b.Title)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package gomark
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event tells the clients that bookmarks changed without them asking
+type Event struct {
+	// "subscribed" or "updated"
+	Type     string
+	DataType string
+	Result   map[string]Bookmark `json:",omitempty"`
+}
+
+// Number of events kept for a slow subscriber before dropping them
+const eventBuffer = 64
+
+// EventHub sends the published events to every subscriber
+type EventHub struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns the channel receiving the events, cancel must be called
+// once they are not read anymore
+func (h *EventHub) Subscribe() (events <-chan Event, cancel func()) {
+
+	ch := make(chan Event, eventBuffer)
+
+	h.mutex.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mutex.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			h.mutex.Lock()
+			delete(h.subscribers, ch)
+			h.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish never blocks, subscribers with a full buffer miss the event
+func (h *EventHub) Publish(e Event) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Event %s dropped for a slow subscriber", e.Type)
+		}
+	}
+}
+
+type eventCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Handler streams the events as JSON over a websocket. With an
+// authenticator the first message of the client must hold its username and
+// password. A "subscribed" event is sent once the client receives the
+// events.
+func (h *EventHub) Handler(authenticator Authenticator) http.Handler {
+
+	upgrader := websocket.Upgrader{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already answered with an error
+			return
+		}
+		defer conn.Close()
+
+		if authenticator != nil {
+			var creds eventCredentials
+
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			err = conn.ReadJSON(&creds)
+
+			if err != nil || !authenticator.CheckCredentials(creds.Username, creds.Password) {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Access Denied"))
+				return
+			}
+
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		events, cancel := h.Subscribe()
+		defer cancel()
+
+		// The client sends nothing more, reading only notices it leaving
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+
+		if err = conn.WriteJSON(Event{Type: "subscribed"}); err != nil {
+			return
+		}
+
+		for e := range events {
+			if err = conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package gomark_test
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/th3osmith/gomark"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testAuthenticator struct{}
+
+func (testAuthenticator) CheckCredentials(username string, password string) bool {
+	return username == "user" && password == "secret"
+}
+
+func TestEventHub(t *testing.T) {
+
+	hub := gomark.NewEventHub()
+
+	ts := httptest.NewServer(hub.Handler(testAuthenticator{}))
+	defer ts.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dial := func(username, password string) *websocket.Conn {
+		c, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+		if err != nil {
+			t.Fatalf("Error while connecting to the events: %v", err)
+		}
+
+		c.WriteJSON(map[string]string{"username": username, "password": password})
+		return c
+	}
+
+	c := dial("user", "secret")
+	defer c.Close()
+
+	var e gomark.Event
+	if err := c.ReadJSON(&e); err != nil || e.Type != "subscribed" {
+		t.Fatalf("Error in the subscription: got %v, %v", e, err)
+	}
+
+	b := gomark.NewBookmark()
+	b.Title = "Enriched"
+	hub.Publish(gomark.Event{Type: "updated", DataType: "bookmark", Result: map[string]gomark.Bookmark{b.ID: *b}})
+
+	e = gomark.Event{}
+	if err := c.ReadJSON(&e); err != nil {
+		t.Fatalf("Error while reading the event: %v", err)
+	}
+
+	if e.Type != "updated" || e.Result[b.ID].Title != "Enriched" {
+		t.Errorf("Error in the event: got %v", e)
+	}
+
+	denied := dial("user", "wrong")
+	defer denied.Close()
+
+	if err := denied.ReadJSON(&e); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Error with wrong credentials: got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package gomark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Fetcher holds the settings of the HTTP requests made to get the pages
+// and to call the APIs of the resolvers
+type Fetcher struct {
+	// Zero means no timeout
+	Timeout time.Duration
+	// Maximum number of bytes read from a response, zero means no limit
+	MaxBodySize  int64
+	MaxRedirects int
+	UserAgent    string
+	// URL of the proxy, the environment variables HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY are used when empty
+	Proxy string
+	// Schemes of the URLs that can be fetched, including after redirects.
+	// Empty means http and https.
+	AllowedSchemes []string
+	// Private, loopback and link-local addresses cannot be reached, except
+	// the networks (CIDR), addresses and host names listed here. The proxy,
+	// from Proxy or the environment, is always allowed, the host of the
+	// requests going through it is resolved and checked instead.
+	AllowedNetworks []string
+}
+
+var DefaultFetcher = Fetcher{
+	Timeout:      10 * time.Second,
+	MaxBodySize:  2 << 20,
+	MaxRedirects: 10,
+	// User Wget User Agent gets better result with Youtube
+	UserAgent:      "Wget/1.19.1 (linux-gnu)",
+	AllowedSchemes: []string{"http", "https"},
+}
+
+// orDefault returns f, or a copy of DefaultFetcher when f is nil
+func (f *Fetcher) orDefault() *Fetcher {
+
+	if f == nil {
+		d := DefaultFetcher
+		return &d
+	}
+
+	return f
+}
+
+func (f *Fetcher) allowed(u *url.URL) error {
+
+	schemes := f.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Scheme %s not allowed: %s", u.Scheme, u)
+}
+
+// Client returns an HTTP client applying the settings of f, except the
+// body size limit which is applied by Get
+func (f *Fetcher) Client() (*http.Client, error) {
+
+	proxyFunc := httpproxy.FromEnvironment().ProxyFunc()
+
+	if len(f.Proxy) > 0 {
+		proxyUrl, err := url.Parse(f.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy %s: %v", f.Proxy, err)
+		}
+		proxyFunc = func(*url.URL) (*url.URL, error) { return proxyUrl, nil }
+	}
+
+	guard, err := newDialGuard(f.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	// The dial guard only sees the connection to the proxy, the host of the
+	// request is checked before, for the first request and the redirects
+	proxy := func(req *http.Request) (*url.URL, error) {
+
+		proxyUrl, err := proxyFunc(req.URL)
+		if err != nil || proxyUrl == nil {
+			return proxyUrl, err
+		}
+
+		if err = guard.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+
+		guard.allowProxy(proxyUrl.Hostname())
+
+		return proxyUrl, nil
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		// Every connection, including the ones of the redirects, is checked
+		// once the host is resolved
+		DialContext:         guard.dialContext(dialer),
+		TLSHandshakeTimeout: 10 * time.Second,
+		// The clients are not reused
+		DisableKeepAlives: true,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   f.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > f.MaxRedirects {
+				return fmt.Errorf("Stopped after %v redirects", f.MaxRedirects)
+			}
+			return f.allowed(req.URL)
+		},
+	}, nil
+}
+
+// Get fetches rawUrl, accept is the value of the Accept header if not
+// empty. The body of the response is cut after MaxBodySize bytes.
+func (f *Fetcher) Get(rawUrl string, accept string) (*http.Response, error) {
+
+	f = f.orDefault()
+
+	req, err := http.NewRequest("GET", rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.allowed(req.URL); err != nil {
+		return nil, err
+	}
+
+	if len(f.UserAgent) > 0 {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+
+	client, err := f.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.MaxBodySize > 0 {
+		res.Body = limitedBody{io.LimitReader(res.Body, f.MaxBodySize), res.Body}
+	}
+
+	return res, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Ranges not covered by the methods of net.IP: shared address space,
+// "this network", IETF protocol assignments and benchmarking
+var reservedNetworks = []string{"100.64.0.0/10", "0.0.0.0/8", "192.0.0.0/24", "198.18.0.0/15"}
+
+func isPrivateIP(ip net.IP) bool {
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, cidr := range reservedNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialGuard refuses the connections to private addresses not allowed
+type dialGuard struct {
+	hosts    map[string]bool
+	networks []*net.IPNet
+	// Host names of the proxies in use, set by the transport
+	mutex   sync.Mutex
+	proxies map[string]bool
+}
+
+func newDialGuard(allowed []string) (*dialGuard, error) {
+
+	g := &dialGuard{hosts: make(map[string]bool), proxies: make(map[string]bool)}
+
+	for _, entry := range allowed {
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid allowed network %s: %v", entry, err)
+			}
+			g.networks = append(g.networks, network)
+			continue
+		}
+
+		if ip := net.ParseIP(entry); ip != nil {
+			g.networks = append(g.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		if len(entry) > 0 {
+			g.hosts[strings.ToLower(entry)] = true
+		}
+	}
+
+	return g, nil
+}
+
+func (g *dialGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+	guarded := *dialer
+	guarded.Control = g.control
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && g.allowedHost(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+func (g *dialGuard) allowProxy(host string) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.proxies[strings.ToLower(host)] = true
+}
+
+func (g *dialGuard) allowedHost(host string) bool {
+
+	host = strings.ToLower(host)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.hosts[host] || g.proxies[host]
+}
+
+// checkHost resolves host and refuses it if one of its addresses is not
+// allowed, for the requests whose connection is made by a proxy
+func (g *dialGuard) checkHost(ctx context.Context, host string) error {
+
+	if g.hosts[strings.ToLower(host)] {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return g.checkIP(ip)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("Impossible to check the host %s: %v", host, err)
+	}
+
+	for _, addr := range addrs {
+		if err = g.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// control is called with the resolved address, so host names pointing to
+// private addresses are caught too
+func (g *dialGuard) control(network, address string, c syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Invalid address %s", address)
+	}
+
+	return g.checkIP(ip)
+}
+
+func (g *dialGuard) checkIP(ip net.IP) error {
+
+	for _, allowed := range g.networks {
+		if allowed.Contains(ip) {
+			return nil
+		}
+	}
+
+	if isPrivateIP(ip) {
+		return fmt.Errorf("Address %s is not allowed", ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetcher reaches the test servers, they listen on the loopback
+// interface
+func testFetcher() *gomark.Fetcher {
+
+	f := gomark.DefaultFetcher
+	f.AllowedNetworks = []string{"127.0.0.0/8", "::1"}
+
+	return &f
+}
+
+func TestFetcher(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/agent", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.UserAgent())
+	})
+
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("a", 1000))
+	})
+
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprint(w, "slow")
+	})
+
+	// /redirect/n redirects n times before reaching /agent
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/redirect/"), "%d", &n)
+
+		if n == 0 {
+			http.Redirect(w, r, "/agent", http.StatusFound)
+		} else {
+			http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+		}
+	})
+
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(f gomark.Fetcher, path string) (string, error) {
+		res, err := f.Get(ts.URL+path, "")
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	f := *testFetcher()
+	f.UserAgent = "gomark-test"
+	f.MaxBodySize = 100
+	f.MaxRedirects = 2
+	f.Timeout = 100 * time.Millisecond
+
+	if body, err := get(f, "/agent"); err != nil || body != "gomark-test" {
+		t.Errorf("Error in the user agent: got %q, %v", body, err)
+	}
+
+	if body, err := get(f, "/big"); err != nil || len(body) != 100 {
+		t.Errorf("Error in the body limit: got %v bytes, %v", len(body), err)
+	}
+
+	if _, err := get(f, "/slow"); err == nil {
+		t.Errorf("No error after the timeout")
+	}
+
+	if body, err := get(f, "/redirect/1"); err != nil || body != "gomark-test" {
+		t.Errorf("Error while following redirects: got %q, %v", body, err)
+	}
+
+	if _, err := get(f, "/redirect/2"); err == nil {
+		t.Errorf("No error after too many redirects")
+	}
+
+	if _, err := get(f, "/ftp"); err == nil {
+		t.Errorf("No error after a redirect to a forbidden scheme")
+	}
+
+	if _, err := f.Get("file:///etc/passwd", ""); err == nil {
+		t.Errorf("No error for a forbidden scheme")
+	}
+
+	f.AllowedSchemes = []string{"https"}
+	if _, err := get(f, "/agent"); err == nil {
+		t.Errorf("No error for http when only https is allowed")
+	}
+}
+
+func TestFetcherProxy(t *testing.T) {
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxies receive the absolute URL
+		fmt.Fprintf(w, "<html><head><title>Proxied %s</title></head></html>", r.URL)
+	}))
+	defer proxy.Close()
+
+	f := testFetcher()
+	f.Proxy = proxy.URL
+
+	u, _ := url.Parse("http://192.0.2.1/page")
+	page, err := gomark.GetPageGeneric(f, u)
+	if err != nil || page.Title != "Proxied http://192.0.2.1/page" {
+		t.Errorf("Error while fetching through a proxy: got %v, %v", page, err)
+	}
+
+	f.Proxy = "://"
+	if _, err := gomark.GetPageGeneric(f, u); err == nil {
+		t.Errorf("No error with an invalid proxy")
+	}
+}
+
+func TestFetcherProxyPrivateAddresses(t *testing.T) {
+
+	var proxied []string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "proxied")
+	}))
+	defer proxy.Close()
+
+	get := func(f gomark.Fetcher, rawUrl string) error {
+		res, err := f.Get(rawUrl, "")
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	// The proxy listens on the loopback interface, it is allowed even if
+	// the loopback network is not
+	f := gomark.DefaultFetcher
+	f.Timeout = time.Second
+	f.Proxy = proxy.URL
+
+	if err := get(f, "http://192.0.2.1/"); err != nil {
+		t.Errorf("Error while fetching through a private proxy: %v", err)
+	}
+
+	// The host of the request is checked, the proxy would reach it
+	for _, rawUrl := range []string{"http://169.254.169.254/latest/meta-data/", "http://10.0.0.1/",
+		"http://192.0.2.1/redirect"} {
+		if err := get(f, rawUrl); err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("Error while fetching %s through a proxy: got %v", rawUrl, err)
+		}
+	}
+
+	expected := []string{"http://192.0.2.1/", "http://192.0.2.1/redirect"}
+	if fmt.Sprint(proxied) != fmt.Sprint(expected) {
+		t.Errorf("Error in proxied requests: got %v expected %v", proxied, expected)
+	}
+
+	f.AllowedNetworks = []string{"10.0.0.0/8"}
+	if err := get(f, "http://10.0.0.1/"); err != nil {
+		t.Errorf("Error while fetching an allowed network through a proxy: %v", err)
+	}
+
+	// Same checks with the proxy of the environment
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	proxied = nil
+
+	f = gomark.DefaultFetcher
+	f.Timeout = time.Second
+
+	if err := get(f, "http://192.0.2.1/"); err != nil {
+		t.Errorf("Error while fetching through the proxy of the environment: %v", err)
+	}
+
+	if err := get(f, "http://169.254.169.254/"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Error while fetching a private address through the proxy of the environment: got %v", err)
+	}
+
+	if len(proxied) != 1 {
+		t.Errorf("Error in proxied requests: got %v", proxied)
+	}
+}
+
+func TestFetcherPrivateAddresses(t *testing.T) {
+
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "private")
+	}))
+	defer private.Close()
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, private.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	get := func(f gomark.Fetcher, rawUrl string) error {
+		res, err := f.Get(rawUrl, "")
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	f := gomark.DefaultFetcher
+	f.Timeout = time.Second
+
+	for _, rawUrl := range []string{private.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:1/",
+		"http://0.0.0.0:1/", "http://100.64.0.1:1/"} {
+		if err := get(f, rawUrl); err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("Error while fetching %s: got %v", rawUrl, err)
+		}
+	}
+
+	// Host names are checked once resolved
+	localhost := strings.Replace(private.URL, "127.0.0.1", "localhost", 1)
+	if err := get(f, localhost); err == nil {
+		t.Errorf("No error while fetching %s", localhost)
+	}
+
+	f.AllowedNetworks = []string{"127.0.0.0/8"}
+	if err := get(f, private.URL); err != nil {
+		t.Errorf("Error while fetching an allowed network: %v", err)
+	}
+
+	f.AllowedNetworks = []string{"127.0.0.1"}
+	if err := get(f, private.URL); err != nil {
+		t.Errorf("Error while fetching an allowed address: %v", err)
+	}
+
+	// Redirects are checked too, only the host name of the first server
+	// is allowed
+	f.AllowedNetworks = []string{"localhost"}
+
+	redirectUrl := strings.Replace(redirect.URL, "127.0.0.1", "localhost", 1)
+	if err := get(f, redirectUrl); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Error while following a redirect to a private address: got %v", err)
+	}
+
+	f.AllowedNetworks = []string{"not a network/8"}
+	if err := get(f, private.URL); err == nil {
+		t.Errorf("No error with an invalid allowed network")
+	}
+}
@@ -1,48 +1,129 @@
 package gomark
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
-	"regexp"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
-var YoutubeKey string
+// Number of previous generations of the database file kept by Dump
+const DefaultBackups = 3
 
+// Size of the journal, in bytes, above which Put and Delete compact it
+const DefaultJournalLimit = 4 << 20
+
+// Database is safe for concurrent use: every access to Bookmarks goes
+// through the mutex, and bookmarks are copied in and out so callers never
+// share the tag sets held by the database.
+//
+// Through the Store interface changes are appended to a journal next to
+// Filename, Dump writes a full snapshot and empties the journal.
 type Database struct {
-	Bookmarks map[string]Bookmark
-	Filename  string
+	SchemaVersion int
+	Bookmarks     map[string]Bookmark
+	Filename      string
+	Backups       int   `json:"-"`
+	JournalLimit  int64 `json:"-"`
+	mutex         sync.RWMutex
+	urls          map[string]string
+	journal       *os.File
+	journalSize   int64
 }
 
 func (d *Database) AddBookmark(b *Bookmark) {
-	d.Bookmarks[b.GetURL()] = *b
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.set(b.clone())
 }
 
+// GetBookmarks returns a snapshot of the bookmarks indexed by ID, later
+// changes to the database are not reflected in it.
 func (d *Database) GetBookmarks() map[string]Bookmark {
-	return d.Bookmarks
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	snapshot := make(map[string]Bookmark, len(d.Bookmarks))
+	for key, b := range d.Bookmarks {
+		snapshot[key] = b.clone()
+	}
+
+	return snapshot
 }
 
-func (d *Database) GetBookmark(url string) (b *Bookmark, err error) {
+func (d *Database) GetBookmark(id string) (b *Bookmark, err error) {
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
 
-	book, ok := d.Bookmarks[url]
+	book, ok := d.Bookmarks[id]
 	if !ok {
-		return nil, errors.New(fmt.Sprintf("Bookmark not found: %s", url))
+		return nil, errors.New(fmt.Sprintf("Bookmark not found: %s", id))
 	}
 
+	book = book.clone()
 	b = &book
 	return
 
 }
 
+func (d *Database) GetBookmarkByURL(url string) (b *Bookmark, err error) {
+
+	d.mutex.RLock()
+	id, ok := d.urls[url]
+	d.mutex.RUnlock()
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Bookmark not found: %s", url))
+	}
+
+	return d.GetBookmark(id)
+}
+
 func (d *Database) DeleteBookmark(b *Bookmark) {
-	delete(d.Bookmarks, b.GetURL())
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.remove(b.ID)
+}
+
+// set stores the bookmark and keeps the URL index up to date, the caller
+// must hold the write lock
+func (d *Database) set(b Bookmark) {
+
+	if old, ok := d.Bookmarks[b.ID]; ok {
+		delete(d.urls, old.GetURL())
+	}
+
+	d.Bookmarks[b.ID] = b
+	d.urls[b.GetURL()] = b.ID
+}
+
+// remove is the counterpart of set
+func (d *Database) remove(id string) {
+
+	if old, ok := d.Bookmarks[id]; ok {
+		delete(d.urls, old.GetURL())
+	}
+
+	delete(d.Bookmarks, id)
+}
+
+func (d *Database) indexURLs() {
+
+	d.urls = make(map[string]string, len(d.Bookmarks))
+	for id, b := range d.Bookmarks {
+		d.urls[b.GetURL()] = id
+	}
 }
 
 func (d *Database) Dump() error {
@@ -51,28 +132,113 @@ func (d *Database) Dump() error {
 		return fmt.Errorf("No file specified")
 	}
 
+	// The write lock also serializes concurrent dumps on the same file
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.dump()
+}
+
+// dump is Dump for callers holding the write lock
+func (d *Database) dump() error {
+
+	d.SchemaVersion = CurrentSchemaVersion
+
 	b, err := json.Marshal(d)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(d.Filename, b, 0600)
-	return err
+	err = writeFileAtomic(d.Filename, b, d.Backups)
+	if err != nil {
+		return err
+	}
+
+	return d.resetJournal()
 }
 
+// NewDatabaseFromFile loads the database stored in filename, or starts a
+// new one if it does not exist. If the file cannot be read or parsed, or is
+// empty, the newest valid backup written by Dump is used instead. The
+// changes recorded in the journal are then replayed on top of it.
 func NewDatabaseFromFile(filename string) (d *Database, err error) {
 
+	d, err = readDatabaseFile(filename, filename)
+	if err == nil {
+		err = d.replayJournal()
+		if err != nil {
+			return nil, err
+		}
+		return
+	}
+
+	// Falling back to an older backup would lose data
+	if _, ok := err.(*SchemaError); ok {
+		return nil, err
+	}
+
+	backups := 0
+
+	for i := 1; ; i++ {
+		backup := backupName(filename, i)
+		if _, errStat := os.Stat(backup); os.IsNotExist(errStat) {
+			break
+		}
+		backups++
+
+		backupDb, errBackup := readDatabaseFile(filename, backup)
+		if errBackup != nil {
+			log.Printf("Ignoring invalid backup %s: %v", backup, errBackup)
+			continue
+		}
+
+		log.Printf("Impossible to load %s (%v), using backup %s", filename, err, backup)
+		return backupDb, backupDb.replayJournal()
+	}
+
+	_, errJournal := os.Stat(journalName(filename))
+
+	// No snapshot has been written yet, everything is in the journal. An
+	// empty file is only the placeholder of older versions when there is a
+	// journal and no backup, otherwise it is a truncated snapshot.
+	if os.IsNotExist(err) || (err == errEmptyDatabase && errJournal == nil && backups == 0) {
+		d = NewDatabase()
+		d.Filename = filename
+		return d, d.replayJournal()
+	}
+
+	if err == errEmptyDatabase {
+		err = fmt.Errorf("Impossible to load %s: %v", filename, err)
+	}
+
+	return nil, err
+}
+
+// errEmptyDatabase is returned for an empty snapshot, the result of an
+// interrupted write rather than of Dump
+var errEmptyDatabase = errors.New("Empty database file")
+
+func readDatabaseFile(filename string, source string) (d *Database, err error) {
+
 	d = NewDatabase()
 	d.Filename = filename
 
-	b, err := ioutil.ReadFile(filename)
+	b, err := ioutil.ReadFile(source)
 	if err != nil {
 		return nil, err
 	}
 
-	// If the file is empty just return a new DB
 	if len(b) == 0 {
-		return
+		return nil, errEmptyDatabase
+	}
+
+	b, err = migrateDocument(b)
+	if _, ok := err.(*SchemaError); ok {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Impossible to load %s: %v", source, err)
 	}
 
 	err = json.Unmarshal(b, d)
@@ -80,20 +246,37 @@ func NewDatabaseFromFile(filename string) (d *Database, err error) {
 		return nil, err
 	}
 
+	d.indexURLs()
+
 	return d, err
 }
 
 func NewDatabase() (d *Database) {
 	d = new(Database)
+	d.SchemaVersion = CurrentSchemaVersion
 	d.Bookmarks = make(map[string]Bookmark)
+	d.urls = make(map[string]string)
+	d.Backups = DefaultBackups
+	d.JournalLimit = DefaultJournalLimit
 	return
 }
 
 type Bookmark struct {
-	Title  string
-	Date   time.Time
-	RawUrl string
-	info   bookmarkInfo // Needed to serialize easily the private attributes
+	ID           string
+	Title        string
+	Date         time.Time
+	RawUrl       string
+	Notes        string // Markdown
+	Description  string
+	Meta         map[string]string
+	Preview      Preview
+	State        BookmarkState
+	StateHistory []StateChange
+	// Result of the last link check, nil until checked
+	Health *Health `json:",omitempty"`
+	// Archived copies of the page, oldest first
+	Snapshots []Snapshot   `json:",omitempty"`
+	info      bookmarkInfo // Needed to serialize easily the private attributes
 }
 
 type bookmarkInfo struct {
@@ -151,190 +334,219 @@ func (d *Bookmark) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func NewBookmark() *Bookmark {
+// clone returns a copy of the bookmark that does not share its tag set
+func (b *Bookmark) clone() Bookmark {
 
-	b := new(Bookmark)
-	b.info.Tags = make(map[string]struct{})
-	b.Date = time.Now()
-
-	return b
-}
-
-func GetTitle(theUrl *url.URL) (title string, err error) {
+	c := *b
+	c.info.Tags = make(map[string]struct{}, len(b.info.Tags))
+	for tag := range b.info.Tags {
+		c.info.Tags[tag] = struct{}{}
+	}
 
-	if YoutubeKey != "" && theUrl.Hostname() == "www.youtube.com" {
-		title, err = GetTitleYoutube(theUrl)
-		if err != nil {
-			log.Printf("Failed to use the youtube API: %s", err)
-		} else {
-			return
+	if b.Meta != nil {
+		c.Meta = make(map[string]string, len(b.Meta))
+		for key, value := range b.Meta {
+			c.Meta[key] = value
 		}
 	}
 
-	return GetTitleGeneric(theUrl)
-}
-
-func GetTitleYoutube(theUrl *url.URL) (title string, err error) {
+	c.StateHistory = append([]StateChange(nil), b.StateHistory...)
 
-	videoId, err := getParam(theUrl.Query(), "v")
-	if err != nil {
-		return
+	if b.Health != nil {
+		health := *b.Health
+		c.Health = &health
 	}
 
-	apiUrl := fmt.Sprintf("https://www.googleapis.com/youtube/v3/videos?id=%s&key=%s&part=snippet", videoId, YoutubeKey)
-
-	resp, err := http.Get(apiUrl)
-	if err != nil {
-		return
-	}
+	c.Snapshots = append([]Snapshot(nil), b.Snapshots...)
 
-	type snippet struct {
-		Title        string `json:"title"`
-		ChannelTitle string `json:"channelTitle"`
-	}
+	return c
+}
 
-	type item struct {
-		Snippet snippet `json:"snippet"`
-	}
+func NewBookmark() *Bookmark {
 
-	type ErrorResponse struct {
-		Errors  []map[string]string `json:"errors"`
-		Code    int                 `json:"code"`
-		Message string              `json:"message"`
-	}
+	b := new(Bookmark)
+	b.ID = newID()
+	b.info.Tags = make(map[string]struct{})
+	b.Date = time.Now()
+	b.State = StateUnread
+	b.StateHistory = []StateChange{{StateUnread, b.Date}}
 
-	type respStruct struct {
-		PageInfo map[string]int `json:"pageInfo"`
-		Items    []item         `json:"items"`
-		Error    ErrorResponse  `json:"error"`
-	}
+	return b
+}
 
-	var data respStruct
+// newID returns a random identifier for a bookmark
+func newID() string {
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("Impossible to generate an ID: %v", err))
 	}
 
-	if data.Error.Code != 0 {
-		fmt.Println("Error")
-		return "", fmt.Errorf("Impossimple to Retrieve Youtube Data: %s", data.Error.Message)
-	}
+	return hex.EncodeToString(id)
+}
 
-	video := data.Items[0].Snippet
-	title = fmt.Sprintf("%s: %s", video.ChannelTitle, video.Title)
+// PageInfo is what is fetched from a page when it is bookmarked
+type PageInfo struct {
+	Title       string
+	Description string
+	Preview     Preview
+}
 
-	return
+func GetTitle(theUrl *url.URL) (title string, err error) {
+	page, err := GetPage(nil, theUrl)
+	return page.Title, err
 }
 
-func getParam(values url.Values, name string) (value string, err error) {
+// GetPage uses the resolver registered for the host of theUrl, and falls
+// back to the generic extraction when there is none or it fails. The
+// requests are made by f, DefaultFetcher when nil.
+func GetPage(f *Fetcher, theUrl *url.URL) (page PageInfo, err error) {
 
-	tmp, ok := values[name]
-	if !ok {
-		err = fmt.Errorf("Param %s not found", name)
-		return
+	if r := ResolverFor(theUrl); r != nil {
+		page, err = r.Resolve(f, theUrl)
+		if err == nil {
+			return
+		}
+
+		if err != ErrNotResolved {
+			log.Printf("Failed to resolve %s: %s", theUrl, err)
+		}
 	}
 
-	value = tmp[0]
-	return
+	return GetPageGeneric(f, theUrl)
 }
 
 func GetTitleGeneric(theUrl *url.URL) (title string, err error) {
+	page, err := GetPageGeneric(nil, theUrl)
+	return page.Title, err
+}
 
-	rawUrl := theUrl.String()
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", rawUrl, nil)
-	if err != nil {
-		err = fmt.Errorf("Error while creating the request for the page %s: %v", rawUrl, err)
-		return
-	}
+func GetPageGeneric(f *Fetcher, theUrl *url.URL) (page PageInfo, err error) {
 
-	// User Wget User Agent gets better result with Youtube
-	req.Header.Set("User-Agent", "Wget/1.19.1 (linux-gnu)")
+	rawUrl := theUrl.String()
 
-	res, err := client.Do(req)
+	res, err := f.Get(rawUrl, "")
 	if err != nil {
 		err = fmt.Errorf("Error while getting the page %s: %v", rawUrl, err)
 		return
 	}
+	defer res.Body.Close()
 
-	// Getting the title
-	head := make([]byte, 2000)
-	_, err = res.Body.Read(head)
-
-	if err != nil && err != io.EOF {
-		// As parsing the title is non mandatory no error is returned
+	page, err = ExtractPage(res.Body, res.Header.Get("Content-Type"))
+	if err != nil {
 		err = fmt.Errorf("Error while reading the page %s: %v", rawUrl, err)
 		return
 	}
 
-	re := regexp.MustCompile("(?s)<title.*?>(.+)</title>")
-	matches := re.FindStringSubmatch(string(head))
-
-	if len(matches) == 0 {
-		rest, erra := ioutil.ReadAll(res.Body)
-
-		if erra != nil && erra != io.EOF {
-			err = fmt.Errorf("Error while reading the full page %s: %v", rawUrl, erra)
-			return
-		}
-
-		bodyText := append(head, rest...)
-		matches = re.FindStringSubmatch(string(bodyText))
-	}
+	// Links are relative to the page reached after the redirects
+	page.Preview.resolve(res.Request.URL)
 
-	if len(matches) == 0 {
+	if len(page.Title) == 0 {
 		err = fmt.Errorf("No Title Found")
-		return
 	}
 
-	title = matches[1]
 	return
 
 }
 
-func NewBookmarkUrl(rawUrl string) (*Bookmark, error) {
+// NewBookmarkRawUrl creates a bookmark for rawUrl without fetching the
+// page, rawUrl is used as the title until the page information is set
+func NewBookmarkRawUrl(rawUrl string) (*Bookmark, error) {
 
 	tmp, err := url.Parse(rawUrl)
-
 	if err != nil {
 		return nil, err
 	}
 
 	b := NewBookmark()
-	b.info.Url = *tmp
+	b.info.Url = *URLCanonicalizer.Canonicalize(tmp)
 	b.RawUrl = rawUrl
+	b.Title = rawUrl
 
-	title, err := GetTitle(tmp)
+	return b, nil
+}
+
+func NewBookmarkUrl(rawUrl string) (*Bookmark, error) {
+
+	b, err := NewBookmarkRawUrl(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := b.fetchPage(nil)
 	if err != nil {
-		b.Title = b.RawUrl
 		log.Printf("Impossible to retrieve title for url %s: %v", b.RawUrl, err)
 	} else {
-		b.Title = title
+		b.SetPage(page)
 	}
 
 	return b, nil
 
 }
 
+func (b *Bookmark) fetchPage(f *Fetcher) (PageInfo, error) {
+
+	tmp, err := url.Parse(b.RawUrl)
+	if err != nil {
+		return PageInfo{}, err
+	}
+
+	return GetPage(f, tmp)
+}
+
+// SetPage sets the title and preview fetched from the page, the
+// description is only set when the bookmark has none
+func (b *Bookmark) SetPage(page PageInfo) {
+
+	b.Title = page.Title
+	b.Preview = page.Preview
+
+	if len(b.Description) == 0 {
+		b.Description = page.Description
+	}
+}
+
 func (b *Bookmark) ResetTags(tags ...string) {
 	b.info.Tags = make(map[string]struct{})
 }
 
+// Tags are case insensitive and hierarchical, the levels are separated by
+// slashes as in lang/go/testing
+func normalizeTag(tag string) string {
+
+	var levels []string
+	for _, level := range strings.Split(strings.ToLower(tag), "/") {
+		if level = strings.TrimSpace(level); len(level) > 0 {
+			levels = append(levels, level)
+		}
+	}
+
+	return strings.Join(levels, "/")
+}
+
+// tagWithin reports whether tag is ancestor or one of its descendants, both
+// normalized
+func tagWithin(tag string, ancestor string) bool {
+	return tag == ancestor || strings.HasPrefix(tag, ancestor+"/")
+}
+
 func (b *Bookmark) AddTags(tags ...string) {
 
 	for _, tag := range tags {
-		tag = strings.ToLower(tag)
+		tag = normalizeTag(tag)
+		if len(tag) == 0 {
+			continue
+		}
 		if _, found := b.info.Tags[tag]; !found {
 			b.info.Tags[tag] = struct{}{}
 		}
 	}
 }
 
+// DeleteTags removes the tags but not their descendants
 func (b *Bookmark) DeleteTags(tags ...string) {
 	for _, tag := range tags {
-		tag = strings.ToLower(tag)
+		tag = normalizeTag(tag)
 		delete(b.info.Tags, tag)
 	}
 }
@@ -348,17 +560,81 @@ func (b *Bookmark) GetTags(tags ...string) (tagSet []string) {
 	return
 }
 
+// HasTags reports whether the bookmark has each of the tags, or one of
+// their descendants
 func (b *Bookmark) HasTags(tags ...string) bool {
+
+next:
 	for _, tag := range tags {
-		tag = strings.ToLower(tag)
-		if _, found := b.info.Tags[tag]; !found {
-			return false
+		tag = normalizeTag(tag)
+		if _, found := b.info.Tags[tag]; found {
+			continue
 		}
+
+		for t := range b.info.Tags {
+			if tagWithin(t, tag) {
+				continue next
+			}
+		}
+
+		return false
 	}
 
 	return true
 }
 
+// SetMeta sets the metadata, an empty value removes the key
+func (b *Bookmark) SetMeta(meta map[string]string) {
+
+	for key, value := range meta {
+		if len(value) == 0 {
+			delete(b.Meta, key)
+			continue
+		}
+
+		if b.Meta == nil {
+			b.Meta = make(map[string]string)
+		}
+		b.Meta[key] = value
+	}
+}
+
+// Matches reports whether text appears, ignoring case, in the URL, title,
+// description, notes, metadata or tags of the bookmark
+func (b *Bookmark) Matches(text string) bool {
+
+	text = strings.ToLower(text)
+
+	fields := []string{b.GetURL(), b.Title, b.Description, b.Notes}
+	for key, value := range b.Meta {
+		fields = append(fields, key, value)
+	}
+	fields = append(fields, b.GetTags()...)
+
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (b *Bookmark) GetURL() string {
 	return b.info.Url.String()
 }
+
+// SetURL points the bookmark to the canonical form of another URL,
+// everything else is kept
+func (b *Bookmark) SetURL(rawUrl string) error {
+
+	tmp, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	b.info.Url = *URLCanonicalizer.Canonicalize(tmp)
+	b.RawUrl = rawUrl
+
+	return nil
+}
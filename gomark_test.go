@@ -1,9 +1,17 @@
 package gomark_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -55,15 +63,26 @@ func TestBookmark(t *testing.T) {
 
 func TestDatabaseFromFile(t *testing.T) {
 
-	emptyPath := os.TempDir() + "/db.json"
-	emptyFile, _ := os.Create(emptyPath)
-	emptyFile.Close()
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
-	_, err := gomark.NewDatabaseFromFile(emptyPath)
+	path := filepath.Join(dir, "db.json")
 
-	if err != nil {
-		t.Error("Empty file not handled")
+	d, err := gomark.NewDatabaseFromFile(path)
+	if err != nil || len(d.Bookmarks) != 0 {
+		t.Errorf("Missing file not handled: %v", err)
+	}
+
+	// Without backup nothing can replace a truncated file
+	emptyFile, _ := os.Create(path)
+	emptyFile.Close()
 
+	_, err = gomark.NewDatabaseFromFile(path)
+	if err == nil {
+		t.Error("Empty file loaded as an empty database")
 	}
 
 }
@@ -93,7 +112,7 @@ func TestDatabase(t *testing.T) {
 	}
 
 	if !reflect.DeepEqual(d, d1) {
-		t.Errorf("Error after loading from JSON, databases not identical: %s, %s", d, d1)
+		t.Errorf("Error after loading from JSON, databases not identical: %v, %v", d, d1)
 	}
 
 	_, err = d.GetBookmark("http://google.coma")
@@ -108,3 +127,109 @@ func TestDatabase(t *testing.T) {
 	}
 
 }
+
+func TestDatabaseConcurrency(t *testing.T) {
+
+	d := gomark.NewDatabase()
+	d.Filename = os.TempDir() + "/concurrent.json"
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			b := gomark.NewBookmark()
+			err := json.Unmarshal([]byte(fmt.Sprintf(`{"Url": "http://example.com/%d"}`, i)), b)
+			if err != nil {
+				t.Errorf("Error while creating bookmark: %s", err)
+				return
+			}
+
+			d.AddBookmark(b)
+			b.AddTags("tata")
+			d.GetBookmarks()
+			d.Dump()
+
+			if b, err = d.GetBookmark(b.ID); err == nil {
+				b.AddTags("yoyo")
+				d.AddBookmark(b)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	books := d.GetBookmarks()
+	if len(books) != 20 {
+		t.Errorf("Error in concurrent add: expected 20 bookmarks got %v", len(books))
+	}
+
+	for _, b := range books {
+		if b.HasTags("tata") || !b.HasTags("yoyo") {
+			t.Errorf("Error in bookmark isolation: got tags %v", b.GetTags())
+		}
+	}
+
+}
+
+func TestBookmarkFields(t *testing.T) {
+
+	b := gomark.NewBookmark()
+	b.SetURL("http://example.com/article")
+	b.Notes = "Saved for the *benchmarks*"
+	b.Description = "An article about Go"
+	b.SetMeta(map[string]string{"author": "Rob", "source": "newsletter"})
+	b.SetMeta(map[string]string{"source": ""})
+
+	if len(b.Meta) != 1 || b.Meta["author"] != "Rob" {
+		t.Errorf("Error in SetMeta: got %v", b.Meta)
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatalf("Error while marshalling: %s", err)
+	}
+
+	b1 := gomark.NewBookmark()
+	if err = json.Unmarshal(data, b1); err != nil {
+		t.Fatalf("Error while unmarshalling: %s", err)
+	}
+
+	if !reflect.DeepEqual(b.Meta, b1.Meta) || b1.Notes != b.Notes || b1.Description != b.Description {
+		t.Errorf("Error in JSON round trip: got %v expected %v", b1, b)
+	}
+
+	for _, text := range []string{"BENCHMARKS", "about go", "rob", "author", "example.com"} {
+		if !b.Matches(text) {
+			t.Errorf("Error in Matches: %s not found", text)
+		}
+	}
+
+	if b.Matches("newsletter") {
+		t.Error("Error in Matches: removed metadata found")
+	}
+
+}
+
+func TestGetPageGeneric(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Test Page</title>
+		<meta content="A page used in tests" name="description"></head></html>`)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	page, err := gomark.GetPageGeneric(testFetcher(), u)
+	if err != nil {
+		t.Fatalf("Error while getting the page: %s", err)
+	}
+
+	if page.Title != "Test Page" || page.Description != "A page used in tests" {
+		t.Errorf("Error in GetPageGeneric: got %v", page)
+	}
+
+}
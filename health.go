@@ -0,0 +1,240 @@
+package gomark
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Health is the result of the last check of the URL of a bookmark
+type Health struct {
+	// HTTP status of the last response, 0 when there was none
+	Status int `json:",omitempty"`
+	// Canonical URL reached after the redirects, when it is not the URL of
+	// the bookmark
+	FinalUrl string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Checked  time.Time
+	// Number of checks failed in a row
+	Failures int `json:",omitempty"`
+}
+
+// Dead reports whether the last check failed
+func (h *Health) Dead() bool {
+	return h != nil && h.Failures > 0
+}
+
+// Redirected reports whether the URL now leads to another page
+func (h *Health) Redirected() bool {
+	return h != nil && h.Failures == 0 && len(h.FinalUrl) > 0
+}
+
+// Default minimum time between two checks of URLs of the same host
+const DefaultCheckHostInterval = 2 * time.Second
+
+// Number of URLs checked at the same time
+const checkWorkers = 4
+
+// Checker requests the URLs of the bookmarks to find the dead links
+type Checker struct {
+	store   Store
+	fetcher *Fetcher
+	limiter hostLimiter
+
+	mutex   sync.Mutex
+	running bool
+}
+
+// NewChecker makes the requests with fetcher, DefaultFetcher when nil, and
+// waits hostInterval between two requests to the same host
+func NewChecker(store Store, fetcher *Fetcher, hostInterval time.Duration) *Checker {
+	return &Checker{
+		store:   store,
+		fetcher: fetcher,
+		limiter: hostLimiter{interval: hostInterval, next: make(map[string]time.Time)},
+	}
+}
+
+// hostLimiter spaces the requests made to each host
+type hostLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+// wait blocks until a request to host is allowed, and reserves the slot
+func (l *hostLimiter) wait(host string) {
+
+	l.mutex.Lock()
+
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+
+	l.mutex.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// checkURL requests the URL of b and returns its new health
+func (c *Checker) checkURL(b *Bookmark) Health {
+
+	previous := Health{}
+	if b.Health != nil {
+		previous = *b.Health
+	}
+
+	health := Health{Checked: time.Now()}
+
+	rawUrl := b.RawUrl
+	if len(rawUrl) == 0 {
+		rawUrl = b.GetURL()
+	}
+
+	c.limiter.wait(b.info.Url.Hostname())
+
+	res, err := c.fetcher.Get(rawUrl, "")
+	if err != nil {
+		health.Error = err.Error()
+		health.Failures = previous.Failures + 1
+		return health
+	}
+	res.Body.Close()
+
+	health.Status = res.StatusCode
+
+	if res.StatusCode >= 400 {
+		health.Error = res.Status
+		health.Failures = previous.Failures + 1
+	}
+
+	// Redirects between spellings of the same URL do not count
+	final := URLCanonicalizer.Canonicalize(res.Request.URL).String()
+	if final != b.GetURL() {
+		health.FinalUrl = final
+	}
+
+	return health
+}
+
+// Check requests the URL of the bookmark with the given ID and stores the
+// result in its Health
+func (c *Checker) Check(id string) (*Bookmark, error) {
+
+	b, err := c.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	health := c.checkURL(b)
+
+	return c.store.Update(id, func(b *Bookmark) error {
+		b.Health = &health
+		return nil
+	})
+}
+
+// Running reports whether CheckAll is running
+func (c *Checker) Running() bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.running
+}
+
+// CheckAll checks every bookmark and returns how many were checked. Only
+// one CheckAll runs at a time.
+func (c *Checker) CheckAll() (n int, err error) {
+
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return 0, fmt.Errorf("A check is already running")
+	}
+	c.running = true
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.running = false
+		c.mutex.Unlock()
+	}()
+
+	var ids []string
+	err = c.store.Iterate(func(b *Bookmark) error {
+		ids = append(ids, b.ID)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	for i := 0; i < checkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				if _, err := c.Check(id); err != nil {
+					log.Printf("Impossible to check %s: %v", id, err)
+					continue
+				}
+
+				mutex.Lock()
+				n++
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+
+	return
+}
+
+// CheckEvery runs CheckAll every interval, until stop is called
+func (c *Checker) CheckEvery(interval time.Duration) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				n, err := c.CheckAll()
+				if err != nil {
+					log.Printf("Impossible to check the bookmarks: %v", err)
+				} else {
+					log.Printf("Checked %v bookmarks", n)
+				}
+
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Values of the health filter of the bookmark Retrieve
+var healthFilters = map[string]func(h *Health) bool{
+	"dead":       (*Health).Dead,
+	"redirected": (*Health).Redirected,
+	"ok":         func(h *Health) bool { return h != nil && !h.Dead() && !h.Redirected() },
+	"unchecked":  func(h *Health) bool { return h == nil },
+}
@@ -0,0 +1,117 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+
+	var dead int32
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&dead) == 1 {
+			http.Error(w, "gone", http.StatusGone)
+		}
+	})
+
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok?utm_source=test", http.StatusMovedPermanently)
+	})
+
+	// Only the trailing slash and a tracking parameter change
+	mux.HandleFunc("/slash/", func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RawQuery) == 0 {
+			http.Redirect(w, r, "/slash/?utm_medium=test", http.StatusFound)
+		}
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	db := gomark.NewDatabase()
+
+	ids := make(map[string]string)
+	for _, path := range []string{"/ok", "/flaky", "/moved", "/slash/", "/missing"} {
+		b, _ := gomark.NewBookmarkRawUrl(ts.URL + path)
+		db.Put(b)
+		ids[path] = b.ID
+	}
+
+	interval := 50 * time.Millisecond
+	checker := gomark.NewChecker(db, testFetcher(), interval)
+
+	start := time.Now()
+
+	n, err := checker.CheckAll()
+	if err != nil || n != 5 {
+		t.Fatalf("Error while checking: got %v, %v", n, err)
+	}
+
+	// Every URL is on the same host
+	if elapsed := time.Since(start); elapsed < 4*interval {
+		t.Errorf("Error in the rate limit: 5 checks in %v", elapsed)
+	}
+
+	health := func(path string) *gomark.Health {
+		b, _ := db.Get(ids[path])
+		if b.Health == nil {
+			t.Fatalf("No health for %s", path)
+		}
+		return b.Health
+	}
+
+	if h := health("/ok"); h.Dead() || h.Redirected() || h.Status != 200 || h.Checked.IsZero() {
+		t.Errorf("Error for /ok: got %v", h)
+	}
+
+	if h := health("/moved"); !h.Redirected() || h.FinalUrl != ts.URL+"/ok" {
+		t.Errorf("Error for /moved: got %v", h)
+	}
+
+	if h := health("/slash/"); h.Redirected() || h.Dead() {
+		t.Errorf("Error for /slash/: got %v", h)
+	}
+
+	if h := health("/missing"); !h.Dead() || h.Status != 404 || h.Failures != 1 {
+		t.Errorf("Error for /missing: got %v", h)
+	}
+
+	// Failures are counted in a row
+	atomic.StoreInt32(&dead, 1)
+	for i := 0; i < 2; i++ {
+		checker.Check(ids["/flaky"])
+	}
+
+	if h := health("/flaky"); !h.Dead() || h.Failures != 2 || h.Status != http.StatusGone {
+		t.Errorf("Error for /flaky: got %v", h)
+	}
+
+	atomic.StoreInt32(&dead, 0)
+	checker.Check(ids["/flaky"])
+
+	if h := health("/flaky"); h.Dead() || h.Failures != 0 {
+		t.Errorf("Error for /flaky after recovery: got %v", h)
+	}
+
+	ts.Close()
+	checker.Check(ids["/ok"])
+
+	if h := health("/ok"); !h.Dead() || h.Status != 0 || len(h.Error) == 0 {
+		t.Errorf("Error for /ok once the server is closed: got %v", h)
+	}
+
+	if _, err := checker.Check("unknown"); err == nil {
+		t.Errorf("No error while checking an unknown bookmark")
+	}
+}
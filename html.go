@@ -0,0 +1,358 @@
+package gomark
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Maximum number of bytes of a page read to find its metadata
+const MaxHeadSize = 256 << 10
+
+// Preview holds what clients need to render a card for a page
+type Preview struct {
+	// OpenGraph
+	Title       string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Image       string `json:",omitempty"`
+	SiteName    string `json:",omitempty"`
+
+	TwitterCard        string `json:",omitempty"`
+	TwitterTitle       string `json:",omitempty"`
+	TwitterDescription string `json:",omitempty"`
+	TwitterImage       string `json:",omitempty"`
+	TwitterSite        string `json:",omitempty"`
+	TwitterCreator     string `json:",omitempty"`
+
+	// JSON-LD
+	Headline      string `json:",omitempty"`
+	Author        string `json:",omitempty"`
+	DatePublished string `json:",omitempty"`
+
+	Canonical string `json:",omitempty"`
+	Favicon   string `json:",omitempty"`
+}
+
+// ExtractPage reads the head of an HTML document and returns its title,
+// description and preview. Reading stops at the end of the head, or after
+// MaxHeadSize bytes. The OpenGraph title and description are preferred to
+// the title and description tags.
+//
+// The document is transcoded to UTF-8 from the encoding given by its BOM,
+// the charset of contentType or its meta tags, in that order.
+func ExtractPage(r io.Reader, contentType string) (page PageInfo, err error) {
+
+	r, err = charset.NewReader(io.LimitReader(r, MaxHeadSize), contentType)
+	if err == io.EOF {
+		// Empty document
+		return page, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	p := pageParser{z: html.NewTokenizer(r)}
+	err = p.parse()
+
+	return p.page(), err
+}
+
+type pageParser struct {
+	z *html.Tokenizer
+
+	title       strings.Builder
+	inTitle     bool
+	titleFound  bool
+	description string
+	preview     Preview
+	iconFound   bool
+
+	jsonLD   strings.Builder
+	inJsonLD bool
+
+	// Depth in svg and math elements, their title is not the page's
+	foreign int
+}
+
+func (p *pageParser) parse() error {
+
+	z := p.z
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			return nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+
+			var attrs map[string]string
+			if hasAttr {
+				attrs = tagAttributes(z)
+			}
+
+			switch string(name) {
+
+			case "svg", "math":
+				if tt == html.StartTagToken {
+					p.foreign++
+				}
+
+			case "title":
+				p.inTitle = p.foreign == 0 && !p.titleFound && tt == html.StartTagToken
+
+			case "meta":
+				p.meta(attrs)
+
+			case "link":
+				p.link(attrs)
+
+			case "script":
+				p.inJsonLD = tt == html.StartTagToken &&
+					strings.ToLower(attrs["type"]) == "application/ld+json"
+
+			case "body":
+				return nil
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+
+			switch string(name) {
+
+			case "svg", "math":
+				if p.foreign > 0 {
+					p.foreign--
+				}
+
+			case "title":
+				if p.inTitle {
+					p.inTitle = false
+					p.titleFound = true
+				}
+
+			case "script":
+				if p.inJsonLD {
+					p.inJsonLD = false
+					p.linkedData(p.jsonLD.String())
+					p.jsonLD.Reset()
+				}
+
+			case "head":
+				return nil
+			}
+
+		case html.TextToken:
+			if p.inTitle {
+				p.title.Write(z.Text())
+			}
+
+			if p.inJsonLD {
+				p.jsonLD.Write(z.Text())
+			}
+		}
+	}
+}
+
+func (p *pageParser) page() (page PageInfo) {
+
+	page.Title = collapseSpaces(p.title.String())
+	page.Description = p.description
+	page.Preview = p.preview
+
+	if len(p.preview.Title) > 0 {
+		page.Title = p.preview.Title
+	}
+
+	if len(p.preview.Description) > 0 {
+		page.Description = p.preview.Description
+	}
+
+	return
+}
+
+// The first value found for each property is kept
+func (p *pageParser) meta(attrs map[string]string) {
+
+	key := strings.ToLower(attrs["property"])
+	if len(key) == 0 {
+		key = strings.ToLower(attrs["name"])
+	}
+
+	value := collapseSpaces(attrs["content"])
+
+	set := func(field *string) {
+		if len(*field) == 0 {
+			*field = value
+		}
+	}
+
+	switch key {
+	case "description":
+		set(&p.description)
+	case "og:title":
+		set(&p.preview.Title)
+	case "og:description":
+		set(&p.preview.Description)
+	case "og:image", "og:image:url":
+		set(&p.preview.Image)
+	case "og:site_name":
+		set(&p.preview.SiteName)
+	case "twitter:card":
+		set(&p.preview.TwitterCard)
+	case "twitter:title":
+		set(&p.preview.TwitterTitle)
+	case "twitter:description":
+		set(&p.preview.TwitterDescription)
+	case "twitter:image", "twitter:image:src":
+		set(&p.preview.TwitterImage)
+	case "twitter:site":
+		set(&p.preview.TwitterSite)
+	case "twitter:creator":
+		set(&p.preview.TwitterCreator)
+	}
+}
+
+func (p *pageParser) link(attrs map[string]string) {
+
+	href := strings.TrimSpace(attrs["href"])
+	if len(href) == 0 {
+		return
+	}
+
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		switch rel {
+
+		case "canonical":
+			if len(p.preview.Canonical) == 0 {
+				p.preview.Canonical = href
+			}
+
+		// Also matches "shortcut icon"
+		case "icon":
+			if !p.iconFound {
+				p.preview.Favicon = href
+				p.iconFound = true
+			}
+
+		case "apple-touch-icon":
+			if len(p.preview.Favicon) == 0 {
+				p.preview.Favicon = href
+			}
+		}
+	}
+}
+
+// linkedData reads the fields of the first JSON-LD object having them, the
+// objects can be nested in arrays or in an @graph
+func (p *pageParser) linkedData(data string) {
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return
+	}
+
+	var visit func(node interface{})
+	visit = func(node interface{}) {
+
+		switch node := node.(type) {
+
+		case []interface{}:
+			for _, child := range node {
+				visit(child)
+			}
+
+		case map[string]interface{}:
+			if headline, ok := node["headline"].(string); ok && len(p.preview.Headline) == 0 {
+				p.preview.Headline = collapseSpaces(headline)
+			}
+
+			if date, ok := node["datePublished"].(string); ok && len(p.preview.DatePublished) == 0 {
+				p.preview.DatePublished = date
+			}
+
+			if author := linkedDataName(node["author"]); len(author) > 0 && len(p.preview.Author) == 0 {
+				p.preview.Author = author
+			}
+
+			visit(node["@graph"])
+		}
+	}
+
+	visit(doc)
+}
+
+// An author is a name, a Person object or a list of them
+func linkedDataName(node interface{}) string {
+
+	switch node := node.(type) {
+
+	case string:
+		return collapseSpaces(node)
+
+	case map[string]interface{}:
+		name, _ := node["name"].(string)
+		return collapseSpaces(name)
+
+	case []interface{}:
+		var names []string
+		for _, child := range node {
+			if name := linkedDataName(child); len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+
+	return ""
+}
+
+// resolve makes the links of the preview absolute, base is the URL of the
+// page. Pages without an icon link get the conventional /favicon.ico.
+func (preview *Preview) resolve(base *url.URL) {
+
+	if len(preview.Favicon) == 0 {
+		preview.Favicon = "/favicon.ico"
+	}
+
+	for _, field := range []*string{&preview.Image, &preview.TwitterImage, &preview.Canonical, &preview.Favicon} {
+		if len(*field) == 0 {
+			continue
+		}
+
+		if ref, err := url.Parse(*field); err == nil {
+			*field = base.ResolveReference(ref).String()
+		}
+	}
+}
+
+// tagAttributes returns the attributes of the current tag, names are lower
+// case and values unescaped
+func tagAttributes(z *html.Tokenizer) map[string]string {
+
+	attrs := make(map[string]string)
+
+	for {
+		key, value, more := z.TagAttr()
+		attrs[string(key)] = string(value)
+
+		if !more {
+			return attrs
+		}
+	}
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
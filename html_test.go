@@ -0,0 +1,167 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractPage(t *testing.T) {
+
+	cases := []struct {
+		name        string
+		doc         string
+		title       string
+		description string
+	}{
+		{"simple", `<html><head><title>Hello</title></head></html>`, "Hello", ""},
+		{"entities", `<title>Tom &amp; Jerry &#8211; &quot;Cartoons&quot;</title>`, `Tom & Jerry – "Cartoons"`, ""},
+		{"whitespace", "<title>\n   A\t long\n\n title  </title>", "A long title", ""},
+		{"attributes", `<TITLE lang="en">Upper</TITLE>`, "Upper", ""},
+		{"svg", `<head><svg><title>Icon</title></svg><title>Page</title></head>`, "Page", ""},
+		{"script", `<head><script>document.write("<title>Fake</title>")</script><title>Real</title></head>`, "Real", ""},
+		{"comment", `<head><!-- <title>Old</title> --><title>New</title></head>`, "New", ""},
+		{"first", `<head><title>First</title><title>Second</title></head>`, "First", ""},
+		{"body", `<head></head><body><title>In body</title></body>`, "", ""},
+		{"description", `<head><meta content="About &amp; more" NAME="Description"><title>T</title></head>`, "T", "About & more"},
+	}
+
+	for _, tc := range cases {
+		page, err := gomark.ExtractPage(strings.NewReader(tc.doc), "text/html")
+		if err != nil {
+			t.Errorf("%s: error while extracting: %s", tc.name, err)
+			continue
+		}
+
+		if page.Title != tc.title || page.Description != tc.description {
+			t.Errorf("%s: got %q, %q expected %q, %q", tc.name, page.Title, page.Description, tc.title, tc.description)
+		}
+	}
+
+	// The title is after the size limit
+	doc := "<head><meta name=padding content=\"" + strings.Repeat("x", gomark.MaxHeadSize) + "\"><title>Far</title>"
+
+	page, err := gomark.ExtractPage(strings.NewReader(doc), "")
+	if err != nil || len(page.Title) != 0 {
+		t.Errorf("Size limit not enforced: got %q (%v)", page.Title, err)
+	}
+
+}
+
+func TestExtractPageCharset(t *testing.T) {
+
+	cases := []struct {
+		fixture     string
+		contentType string
+		title       string
+	}{
+		// Declared in a meta tag
+		{"latin1.html", "text/html", "Café à la crème"},
+		{"windows1251.html", "text/html", "Привет, мир"},
+		// Declared in the header only
+		{"shift_jis.html", "text/html; charset=Shift_JIS", "日本語のタイトル"},
+		// The BOM wins over a wrong header
+		{"utf16_bom.html", "text/html; charset=iso-8859-1", "Ünïcödé with BOM"},
+	}
+
+	for _, tc := range cases {
+
+		fixture, err := ioutil.ReadFile(filepath.Join("testdata", tc.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tc.contentType)
+			w.Write(fixture)
+		}))
+
+		u, _ := url.Parse(ts.URL)
+		page, err := gomark.GetPageGeneric(testFetcher(), u)
+		ts.Close()
+
+		if err != nil {
+			t.Errorf("%s: error while getting the page: %s", tc.fixture, err)
+			continue
+		}
+
+		if page.Title != tc.title {
+			t.Errorf("%s: got %q expected %q", tc.fixture, page.Title, tc.title)
+		}
+	}
+
+}
+
+func TestExtractPreview(t *testing.T) {
+
+	doc := `<html><head>
+	<title>Plain title</title>
+	<meta name="description" content="Plain description">
+	<meta property="og:title" content="OpenGraph &amp; title">
+	<meta property="og:description" content="OpenGraph description">
+	<meta property="og:image" content="/img/card.png">
+	<meta property="og:site_name" content="Example">
+	<meta name="twitter:card" content="summary_large_image">
+	<meta name="twitter:creator" content="@gopher">
+	<link rel="canonical" href="https://example.com/article">
+	<link rel="apple-touch-icon" href="/apple.png">
+	<link rel="shortcut icon" href="/icon.png">
+	<script type="application/ld+json">
+	{"@context": "https://schema.org", "@graph": [
+		{"@type": "WebSite", "name": "Example"},
+		{"@type": "NewsArticle", "headline": "The  headline", "datePublished": "2024-01-02",
+		 "author": [{"@type": "Person", "name": "Ada"}, {"@type": "Person", "name": "Grace"}]}
+	]}
+	</script>
+	</head><body></body></html>`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/articles/1" {
+			http.Redirect(w, r, "/articles/1", http.StatusFound)
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/short")
+
+	page, err := gomark.GetPageGeneric(testFetcher(), u)
+	if err != nil {
+		t.Fatalf("Error while getting the page: %s", err)
+	}
+
+	expected := gomark.Preview{
+		Title:          "OpenGraph & title",
+		Description:    "OpenGraph description",
+		Image:          ts.URL + "/img/card.png",
+		SiteName:       "Example",
+		TwitterCard:    "summary_large_image",
+		TwitterCreator: "@gopher",
+		Headline:       "The headline",
+		Author:         "Ada, Grace",
+		DatePublished:  "2024-01-02",
+		Canonical:      "https://example.com/article",
+		Favicon:        ts.URL + "/icon.png",
+	}
+
+	if page.Preview != expected {
+		t.Errorf("Error in preview: got %+v expected %+v", page.Preview, expected)
+	}
+
+	if page.Title != "OpenGraph & title" || page.Description != "OpenGraph description" {
+		t.Errorf("OpenGraph not preferred: got %q, %q", page.Title, page.Description)
+	}
+
+	// Without icon link
+	page, _ = gomark.ExtractPage(strings.NewReader("<title>No icon</title>"), "text/html")
+	if len(page.Preview.Favicon) != 0 {
+		t.Errorf("Favicon invented before resolution: %s", page.Preview.Favicon)
+	}
+
+}
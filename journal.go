@@ -0,0 +1,232 @@
+package gomark
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// A journal is a file of JSON entries, one per line, each recording a
+// change made to the database since the last snapshot
+type journalEntry struct {
+	Op       string
+	ID       string    `json:",omitempty"`
+	Url      string    `json:",omitempty"` // Deletions written before bookmarks had an ID
+	Bookmark *Bookmark `json:",omitempty"`
+}
+
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+)
+
+func journalName(filename string) string {
+	return filename + ".journal"
+}
+
+// writeJournal appends the entry to the journal, the caller must hold the
+// write lock
+func (d *Database) writeJournal(entry journalEntry) error {
+
+	if len(d.Filename) == 0 {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if d.journal == nil {
+		d.journal, err = os.OpenFile(journalName(d.Filename), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := d.journal.Write(append(line, '\n'))
+	if err != nil {
+		// A partial entry would be followed by the next ones on its line
+		if n > 0 && d.journal.Truncate(d.journalSize) != nil {
+			d.journalSize += int64(n)
+		}
+		return fmt.Errorf("Error while writing the journal: %v", err)
+	}
+	d.journalSize += int64(n)
+
+	return d.journal.Sync()
+}
+
+// compactIfNeeded writes a snapshot once the journal exceeds JournalLimit
+func (d *Database) compactIfNeeded() error {
+
+	d.mutex.RLock()
+	full := d.JournalLimit > 0 && d.journalSize > d.JournalLimit
+	d.mutex.RUnlock()
+
+	if !full {
+		return nil
+	}
+
+	return d.Dump()
+}
+
+// resetJournal empties the journal once a snapshot has been written, the
+// caller must hold the write lock
+func (d *Database) resetJournal() error {
+
+	d.journalSize = 0
+
+	if d.journal == nil {
+		err := os.Remove(journalName(d.Filename))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return d.journal.Truncate(0)
+}
+
+// replayJournal applies the entries of the journal to the snapshot just
+// loaded. An incomplete or invalid entry, left by a crash in the middle of
+// an append, ends the replay and is cut from the journal.
+func (d *Database) replayJournal() error {
+
+	name := journalName(d.Filename)
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	var valid int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+
+		if err == io.EOF {
+			log.Printf("Ignoring incomplete entry at the end of %s", name)
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var entry journalEntry
+		err = json.Unmarshal(line, &entry)
+		if err == nil {
+			err = d.applyJournalEntry(entry)
+		}
+
+		if err != nil {
+			log.Printf("Ignoring invalid entry at offset %v of %s and the following ones: %v", valid, name, err)
+			break
+		}
+
+		valid += int64(len(line))
+	}
+
+	d.journalSize = valid
+
+	if info, err := f.Stat(); err == nil && info.Size() > valid {
+		return os.Truncate(name, valid)
+	}
+
+	return nil
+}
+
+func (d *Database) applyJournalEntry(entry journalEntry) error {
+
+	switch entry.Op {
+
+	case journalPut:
+		b := entry.Bookmark
+		if b == nil {
+			return fmt.Errorf("Missing bookmark")
+		}
+
+		// Entries written before bookmarks had an ID
+		if len(b.ID) == 0 {
+			b.ID = d.urls[b.GetURL()]
+		}
+		if len(b.ID) == 0 {
+			b.ID = legacyID(b.GetURL())
+		}
+
+		d.set(*b)
+
+	case journalDelete:
+		if len(entry.ID) == 0 {
+			entry.ID = d.urls[entry.Url]
+		}
+		d.remove(entry.ID)
+
+	default:
+		return fmt.Errorf("Unknown operation %s", entry.Op)
+	}
+
+	return nil
+}
+
+// CompactEvery writes a snapshot every interval if the journal is not
+// empty, until stop is called
+func (d *Database) CompactEvery(interval time.Duration) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				d.mutex.RLock()
+				pending := d.journalSize > 0
+				d.mutex.RUnlock()
+
+				if !pending {
+					continue
+				}
+
+				if err := d.Dump(); err != nil {
+					log.Printf("Impossible to compact %s: %v", d.Filename, err)
+				}
+
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (d *Database) closeJournal() error {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.journal == nil {
+		return nil
+	}
+
+	err := d.journal.Close()
+	d.journal = nil
+	return err
+}
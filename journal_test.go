@@ -0,0 +1,170 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	journal := filename + ".journal"
+
+	d := gomark.NewDatabase()
+	d.Filename = filename
+
+	d.Put(newTestBookmark(t, "http://example.com/a", "tata"))
+	second := newTestBookmark(t, "http://example.com/b")
+	d.Put(second)
+	first, _ := d.GetByURL("http://example.com/a")
+	first.ResetTags()
+	first.AddTags("yoyo")
+	d.Put(first)
+	d.Delete(second.ID)
+
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error("Snapshot written on a simple change")
+	}
+
+	// Simulate a crash in the middle of an append
+	f, _ := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"Op": "put", "Bookma`)
+	f.Close()
+
+	d1, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Error while replaying the journal: %s", err)
+	}
+
+	if len(d1.Bookmarks) != 1 {
+		t.Fatalf("Error in journal replay: expected 1 bookmark got %v", len(d1.Bookmarks))
+	}
+
+	b, _ := d1.GetByURL("http://example.com/a")
+	if !b.HasTags("yoyo") || b.HasTags("tata") {
+		t.Errorf("Error in journal replay: got tags %v", b.GetTags())
+	}
+
+	// The incomplete entry must not corrupt the next ones
+	d1.Put(newTestBookmark(t, "http://example.com/c"))
+
+	d2, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil || len(d2.Bookmarks) != 2 {
+		t.Errorf("Error after appending to a repaired journal: %v bookmarks (%v)", len(d2.Bookmarks), err)
+	}
+
+	if err = d2.Close(); err != nil {
+		t.Errorf("Error while closing: %s", err)
+	}
+
+	if info, err := os.Stat(journal); err == nil && info.Size() != 0 {
+		t.Errorf("Journal not emptied by the snapshot: %v bytes", info.Size())
+	}
+
+	d3, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil || len(d3.Bookmarks) != 2 {
+		t.Errorf("Error after compaction: %v bookmarks (%v)", len(d3.Bookmarks), err)
+	}
+
+}
+
+func TestJournalCompaction(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	journal := filename + ".journal"
+
+	d := gomark.NewDatabase()
+	d.Filename = filename
+	d.JournalLimit = 1000
+
+	for i := 0; i < 20; i++ {
+		d.Put(newTestBookmark(t, fmt.Sprintf("http://example.com/%d", i)))
+	}
+
+	info, err := os.Stat(journal)
+	if err != nil || info.Size() > 1000 {
+		t.Errorf("Journal not compacted when over the limit (%v)", err)
+	}
+
+	d1, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil || len(d1.Bookmarks) != 20 {
+		t.Errorf("Error after compaction: %v bookmarks (%v)", len(d1.Bookmarks), err)
+	}
+
+	// Periodic compaction
+	d.JournalLimit = 0
+	d.Put(newTestBookmark(t, "http://example.com/last"))
+
+	stop := d.CompactEvery(10 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	info, err = os.Stat(journal)
+	if err != nil || info.Size() != 0 {
+		t.Errorf("Journal not compacted periodically (%v)", err)
+	}
+
+}
+
+func TestJournalWriteFailure(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	d := gomark.NewDatabase()
+	d.Filename = filename
+
+	kept := newTestBookmark(t, "http://example.com/a")
+	d.AddBookmark(kept)
+
+	// The journal cannot be opened
+	if err = os.Mkdir(filename+".journal", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Put(newTestBookmark(t, "http://example.com/b")); err == nil {
+		t.Error("No error when the journal cannot be written")
+	}
+
+	if _, err = d.GetByURL("http://example.com/b"); err == nil {
+		t.Error("Bookmark added in memory without its journal entry")
+	}
+
+	if _, err = d.Update(kept.ID, func(b *gomark.Bookmark) error {
+		b.AddTags("tata")
+		return nil
+	}); err == nil {
+		t.Error("No error when the journal cannot be written")
+	}
+
+	if err = d.Delete(kept.ID); err == nil {
+		t.Error("No error when the journal cannot be written")
+	}
+
+	b, err := d.Get(kept.ID)
+	if err != nil || b.HasTags("tata") {
+		t.Errorf("Bookmark changed in memory without its journal entry: %v", err)
+	}
+}
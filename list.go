@@ -0,0 +1,195 @@
+package gomark
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Default number of bookmarks in a page of results
+const DefaultPageLimit = 100
+
+// Maximum number of bookmarks in a page of results
+const MaxPageLimit = 1000
+
+// Keys the bookmarks can be sorted by, compared as strings
+var sortKeys = map[string]func(b *Bookmark) string{
+	// Fixed width so the dates sort as strings
+	"date":  func(b *Bookmark) string { return b.Date.UTC().Format("2006-01-02T15:04:05.000000000") },
+	"title": func(b *Bookmark) string { return strings.ToLower(b.Title) },
+	"host":  func(b *Bookmark) string { return strings.ToLower(b.info.Url.Hostname()) },
+}
+
+// PageRequest selects a page of sorted bookmarks. Cursor is empty for the
+// first page, the next pages start after the cursor returned with the
+// previous one.
+type PageRequest struct {
+	// date, title or host, the date by default
+	Sort       string
+	Descending bool
+	// DefaultPageLimit when 0, at most MaxPageLimit
+	Limit  int
+	Cursor string
+}
+
+// pageCursor is the position of the last bookmark of a page, the ID breaks
+// the ties between equal keys
+type pageCursor struct {
+	Sort       string
+	Descending bool
+	Key        string
+	ID         string
+}
+
+// Page sorts the bookmarks in place and returns the requested page, with
+// the cursor of the next one or an empty cursor for the last page
+func (r PageRequest) Page(books []Bookmark) (page []Bookmark, next string, err error) {
+
+	if len(r.Sort) == 0 {
+		r.Sort = "date"
+	}
+
+	key, ok := sortKeys[r.Sort]
+	if !ok {
+		return nil, "", fmt.Errorf("Unknown sort %s", r.Sort)
+	}
+
+	if r.Limit < 0 || r.Limit > MaxPageLimit {
+		return nil, "", fmt.Errorf("Invalid limit %v, the maximum is %v", r.Limit, MaxPageLimit)
+	}
+
+	if r.Limit == 0 {
+		r.Limit = DefaultPageLimit
+	}
+
+	keys := make(map[string]string, len(books))
+	for i := range books {
+		keys[books[i].ID] = key(&books[i])
+	}
+
+	// before tells whether the bookmark of key k1 and ID id1 comes first
+	before := func(k1, id1, k2, id2 string) bool {
+		if k1 != k2 {
+			return (k1 < k2) != r.Descending
+		}
+		return id1 != id2 && (id1 < id2) != r.Descending
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		return before(keys[books[i].ID], books[i].ID, keys[books[j].ID], books[j].ID)
+	})
+
+	start := 0
+
+	if len(r.Cursor) > 0 {
+		c, err := decodeCursor(r.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if c.Sort != r.Sort || c.Descending != r.Descending {
+			return nil, "", fmt.Errorf("The cursor belongs to another sort")
+		}
+
+		start = sort.Search(len(books), func(i int) bool {
+			return before(c.Key, c.ID, keys[books[i].ID], books[i].ID)
+		})
+	}
+
+	end := start + r.Limit
+	if end >= len(books) {
+		return books[start:], "", nil
+	}
+
+	page = books[start:end]
+	last := page[len(page)-1]
+
+	next, err = encodeCursor(pageCursor{r.Sort, r.Descending, keys[last.ID], last.ID})
+
+	return page, next, err
+}
+
+func encodeCursor(c pageCursor) (string, error) {
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (c pageCursor, err error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("Invalid cursor %q", s)
+	}
+
+	return c, nil
+}
+
+// Fields of the JSON encoding of a bookmark
+var bookmarkFields = []string{
+	"ID", "Url", "Tags", "Title", "Date", "RawUrl", "Notes", "Description", "Meta",
+	"Preview", "State", "StateHistory", "Health", "Snapshots",
+}
+
+// projectionFields checks the field names, ignoring their case, and
+// returns them as in the JSON encoding of a bookmark. The ID is always
+// included.
+func projectionFields(fields []string) ([]string, error) {
+
+	projection := []string{"ID"}
+
+	for _, field := range fields {
+		found := false
+
+		for _, name := range bookmarkFields {
+			if strings.EqualFold(field, name) {
+				if name != "ID" {
+					projection = append(projection, name)
+				}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("Unknown field %s", field)
+		}
+	}
+
+	return projection, nil
+}
+
+// project returns the JSON encoding of the given fields of the bookmark,
+// the fields are checked by projectionFields
+func (b *Bookmark) project(fields []string) (map[string]json.RawMessage, error) {
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err = json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	projection := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		// Empty fields omitted from the encoding
+		if value, ok := all[field]; ok {
+			projection[field] = value
+		}
+	}
+
+	return projection, nil
+}
@@ -0,0 +1,81 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"testing"
+	"time"
+)
+
+func TestPage(t *testing.T) {
+
+	var books []gomark.Bookmark
+
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, rawUrl := range []string{"http://b.com/", "http://a.com/", "http://c.com/", "http://d.com/"} {
+		b := newTestBookmark(t, rawUrl)
+		b.Date = date.Add(time.Duration(i) * time.Hour)
+		books = append(books, *b)
+	}
+
+	urls := func(page []gomark.Bookmark) (urls []string) {
+		for _, b := range page {
+			urls = append(urls, b.GetURL())
+		}
+		return
+	}
+
+	// Follows the cursors until the last page
+	all := func(r gomark.PageRequest) (pages [][]string) {
+		for {
+			page, next, err := r.Page(append([]gomark.Bookmark(nil), books...))
+			if err != nil {
+				t.Fatalf("Error while paginating %v: %v", r, err)
+			}
+
+			pages = append(pages, urls(page))
+
+			if len(next) == 0 {
+				return
+			}
+			r.Cursor = next
+		}
+	}
+
+	cases := []struct {
+		request gomark.PageRequest
+		pages   string
+	}{
+		{gomark.PageRequest{}, "[[http://b.com/ http://a.com/ http://c.com/ http://d.com/]]"},
+		{gomark.PageRequest{Limit: 3, Descending: true}, "[[http://d.com/ http://c.com/ http://a.com/] [http://b.com/]]"},
+		{gomark.PageRequest{Sort: "title", Limit: 2}, "[[http://a.com/ http://b.com/] [http://c.com/ http://d.com/]]"},
+		{gomark.PageRequest{Sort: "host", Limit: 1}, "[[http://a.com/] [http://b.com/] [http://c.com/] [http://d.com/]]"},
+		{gomark.PageRequest{Sort: "host", Limit: 2, Descending: true}, "[[http://d.com/ http://c.com/] [http://b.com/ http://a.com/]]"},
+		{gomark.PageRequest{Limit: 4}, "[[http://b.com/ http://a.com/ http://c.com/ http://d.com/]]"},
+	}
+
+	for _, c := range cases {
+		if pages := fmt.Sprint(all(c.request)); pages != c.pages {
+			t.Errorf("Error while paginating %v: got %s, expected %s", c.request, pages, c.pages)
+		}
+	}
+
+	// The cursor survives the deletion of the last bookmark of the page
+	_, next, _ := gomark.PageRequest{Sort: "title", Limit: 2}.Page(books)
+	page, _, _ := gomark.PageRequest{Sort: "title", Limit: 2, Cursor: next}.Page(append(books[:1:1], books[2:]...))
+	if got := fmt.Sprint(urls(page)); got != "[http://c.com/ http://d.com/]" {
+		t.Errorf("Error after a deletion: got %s", got)
+	}
+
+	for _, r := range []gomark.PageRequest{
+		{Sort: "url"},
+		{Limit: gomark.MaxPageLimit + 1},
+		{Cursor: "nope"},
+		{Sort: "host", Cursor: next},
+	} {
+		if _, _, err := r.Page(books); err == nil {
+			t.Errorf("No error for %v", r)
+		}
+	}
+}
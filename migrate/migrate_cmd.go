@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"os"
+)
+
+// Imports a JSON database file into an SQLite store
+func main() {
+
+	from := flag.String("from", os.Getenv("HOME")+"/.gomark/db.json", "JSON database to import")
+	to := flag.String("to", os.Getenv("HOME")+"/.gomark/db.sqlite", "SQLite store to create or update")
+	flag.Parse()
+
+	db, err := gomark.NewDatabaseFromFile(*from)
+	checkFatal(err, "Reading JSON DB")
+
+	store, err := gomark.NewSQLiteStore(*to)
+	checkFatal(err, "Opening SQLite DB")
+
+	n, err := gomark.CopyBookmarks(store, db)
+	checkFatal(err, "Importing bookmarks")
+
+	err = store.Close()
+	checkFatal(err, "Closing SQLite DB")
+
+	fmt.Printf("Imported %v bookmarks from %s into %s\n", n, *from, *to)
+
+}
+
+func checkFatal(err error, context string) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%v] %v\n", context, err)
+		os.Exit(1)
+	}
+}
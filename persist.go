@@ -0,0 +1,121 @@
+package gomark
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces filename with data without ever leaving a
+// partially written file behind: the data is written and synced to a
+// temporary file in the same directory which is then renamed over the
+// original. The previous content is kept as the first of backups rotated
+// generations.
+func writeFileAtomic(filename string, data []byte, backups int) (err error) {
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return
+	}
+
+	// Do not leave the temporary file around if anything goes wrong
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if err = rotateBackups(filename, backups); err != nil {
+		return fmt.Errorf("Error while rotating backups of %s: %v", filename, err)
+	}
+
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return
+	}
+
+	// Persist the rename itself, not supported everywhere so errors are ignored
+	if dir, errDir := os.Open(filepath.Dir(filename)); errDir == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return
+}
+
+func backupName(filename string, generation int) string {
+	return fmt.Sprintf("%s.%d", filename, generation)
+}
+
+// rotateBackups shifts filename.1 ... filename.(n-1) by one generation and
+// saves the current content of filename as filename.1
+func rotateBackups(filename string, n int) error {
+
+	if n <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+
+	for i := n - 1; i >= 1; i-- {
+		err := os.Rename(backupName(filename, i), backupName(filename, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	first := backupName(filename, 1)
+	if err := os.Remove(first); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// A hard link keeps the original in place until the rename, fallback
+	// to a copy on filesystems without links
+	if err := os.Link(filename, first); err == nil {
+		return nil
+	}
+
+	return copyFile(filename, first)
+}
+
+func copyFile(src string, dst string) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,90 @@
+package gomark_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBookmark(t *testing.T, rawUrl string, tags ...string) *gomark.Bookmark {
+
+	b := gomark.NewBookmark()
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{"Url": %q, "Title": %q}`, rawUrl, rawUrl)), b)
+	if err != nil {
+		t.Fatalf("Error while creating bookmark for %s: %s", rawUrl, err)
+	}
+
+	b.AddTags(tags...)
+	return b
+}
+
+func TestDumpBackups(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	d := gomark.NewDatabase()
+	d.Filename = filename
+	d.Backups = 2
+
+	for i := 0; i < 4; i++ {
+		d.AddBookmark(newTestBookmark(t, fmt.Sprintf("http://example.com/%d", i)))
+		if err := d.Dump(); err != nil {
+			t.Fatalf("Error while dumping: %s", err)
+		}
+	}
+
+	files, _ := filepath.Glob(filename + "*")
+	if len(files) != 3 {
+		t.Errorf("Error in backup rotation: expected 3 files got %v", files)
+	}
+
+	// Simulate a crash in the middle of a plain rewrite
+	err = ioutil.WriteFile(filename, []byte(`{"Bookmarks": {"http://exa`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Backup not used for a corrupted database: %s", err)
+	}
+
+	if len(d1.Bookmarks) != 3 {
+		t.Errorf("Error in backup fallback: expected 3 bookmarks got %v", len(d1.Bookmarks))
+	}
+
+	if d1.Filename != filename {
+		t.Errorf("Error in backup fallback: database bound to %s instead of %s", d1.Filename, filename)
+	}
+
+	// A truncated write leaves an empty file
+	if err = ioutil.WriteFile(filename, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err = gomark.NewDatabaseFromFile(filename)
+	if err != nil || len(d1.Bookmarks) != 3 {
+		t.Errorf("Backup not used for an empty database: got %v", err)
+	}
+
+	// Every generation is corrupted
+	for _, f := range files {
+		ioutil.WriteFile(f, []byte("{"), 0600)
+	}
+
+	_, err = gomark.NewDatabaseFromFile(filename)
+	if err == nil {
+		t.Error("Corrupted database and backups not reported")
+	}
+
+}
@@ -0,0 +1,427 @@
+package gomark
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Query selects bookmarks. Queries are parsed from expressions like
+//
+//	tag:go -tag:old site:github.com after:2024-01-01 "free text"
+//
+// Terms next to each other must all match, OR and parentheses group
+// alternatives, NOT or a leading - negates a term. The terms are:
+//
+//	tag:TAG        the bookmark has the tag
+//	site:HOST      the URL is on HOST or one of its subdomains
+//	after:DATE     the bookmark was created on DATE (2006-01-02) or later
+//	before:DATE    the bookmark was created before DATE
+//	word, "text"   the text appears in the bookmark, see Bookmark.Matches
+type Query interface {
+	Match(b *Bookmark) bool
+	String() string
+}
+
+// QueryError tells where the parsing of a query failed
+type QueryError struct {
+	// Offset in bytes of the faulty token
+	Position int
+	Message  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query at position %v: %s", e.Position, e.Message)
+}
+
+type andQuery struct{ left, right Query }
+type orQuery struct{ left, right Query }
+type notQuery struct{ query Query }
+type tagQuery struct{ tag string }
+type siteQuery struct{ host string }
+type textQuery struct{ text string }
+
+type dateQuery struct {
+	date  time.Time
+	after bool
+}
+
+func (q andQuery) Match(b *Bookmark) bool { return q.left.Match(b) && q.right.Match(b) }
+func (q orQuery) Match(b *Bookmark) bool  { return q.left.Match(b) || q.right.Match(b) }
+func (q notQuery) Match(b *Bookmark) bool { return !q.query.Match(b) }
+func (q tagQuery) Match(b *Bookmark) bool { return b.HasTags(q.tag) }
+
+func (q siteQuery) Match(b *Bookmark) bool {
+	host := strings.ToLower(b.info.Url.Hostname())
+	return host == q.host || strings.HasSuffix(host, "."+q.host)
+}
+
+func (q textQuery) Match(b *Bookmark) bool { return b.Matches(q.text) }
+
+func (q dateQuery) Match(b *Bookmark) bool {
+	if q.after {
+		return !b.Date.Before(q.date)
+	}
+	return b.Date.Before(q.date)
+}
+
+// selectBookmarks returns the bookmarks of store that may match q, a
+// superset when an index of the store is used, every bookmark otherwise
+func selectBookmarks(store Store, q Query) ([]Bookmark, error) {
+
+	// The index only follows the changes
+	if indexed, ok := store.(*IndexedStore); ok {
+		store = indexed.Store
+	}
+
+	if finder, ok := store.(Finder); ok {
+		if books, ok, err := find(finder, q); ok {
+			return books, err
+		}
+	}
+
+	all, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	books := make([]Bookmark, 0, len(all))
+	for _, b := range all {
+		books = append(books, b)
+	}
+
+	return books, nil
+}
+
+// find uses the first tag, site or date required by q, ok is false if
+// there is none
+func find(finder Finder, q Query) (books []Bookmark, ok bool, err error) {
+
+	switch q := q.(type) {
+
+	case tagQuery:
+		books, err = finder.FindByTag(q.tag)
+
+	case siteQuery:
+		books, err = finder.FindByHost(q.host)
+
+	case dateQuery:
+		if q.after {
+			books, err = finder.FindByDate(q.date, time.Unix(1<<62, 0))
+		} else {
+			books, err = finder.FindByDate(time.Unix(-1<<62, 0), q.date)
+		}
+
+	case andQuery:
+		if books, ok, err = find(finder, q.left); ok {
+			return
+		}
+		return find(finder, q.right)
+
+	default:
+		return nil, false, nil
+	}
+
+	return books, true, err
+}
+
+func (q andQuery) String() string  { return fmt.Sprintf("(%s AND %s)", q.left, q.right) }
+func (q orQuery) String() string   { return fmt.Sprintf("(%s OR %s)", q.left, q.right) }
+func (q notQuery) String() string  { return fmt.Sprintf("NOT %s", q.query) }
+func (q tagQuery) String() string  { return "tag:" + quoteTerm(q.tag) }
+func (q siteQuery) String() string { return "site:" + quoteTerm(q.host) }
+func (q textQuery) String() string { return quoteTerm(q.text) }
+
+func (q dateQuery) String() string {
+	if q.after {
+		return "after:" + q.date.Format(queryDateFormat)
+	}
+	return "before:" + q.date.Format(queryDateFormat)
+}
+
+func quoteTerm(s string) string {
+	if strings.ContainsAny(s, " \t\"()") || len(s) == 0 {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+const queryDateFormat = "2006-01-02"
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// The word was entirely quoted, it is only text
+	quoted bool
+	pos    int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenLParen:
+		return "("
+	case tokenRParen:
+		return ")"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexQuery splits a query into tokens, quotes group words and can follow
+// a field name as in tag:"machine learning"
+func lexQuery(s string) ([]token, error) {
+
+	var tokens []token
+
+	for i := 0; i < len(s); {
+
+		c := s[i]
+
+		switch {
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i})
+			i++
+
+		// A leading - negates what follows it
+		case c == '-' && i+1 < len(s) && !strings.ContainsRune(" \t\n\r)", rune(s[i+1])):
+			tokens = append(tokens, token{kind: tokenNot, text: "-", pos: i})
+			i++
+
+		default:
+			start := i
+			var word strings.Builder
+			quoted := c == '"'
+
+			for i < len(s) && !strings.ContainsRune(" \t\n\r()", rune(s[i])) {
+
+				if s[i] != '"' {
+					word.WriteByte(s[i])
+					i++
+					continue
+				}
+
+				end := strings.IndexByte(s[i+1:], '"')
+				if end < 0 {
+					return nil, &QueryError{i, "Unterminated quote"}
+				}
+
+				word.WriteString(s[i+1 : i+1+end])
+				i += end + 2
+			}
+
+			t := token{kind: tokenWord, text: word.String(), quoted: quoted, pos: start}
+
+			if !quoted {
+				switch t.text {
+				case "AND":
+					t.kind = tokenAnd
+				case "OR":
+					t.kind = tokenOr
+				case "NOT":
+					t.kind = tokenNot
+				}
+			}
+
+			tokens = append(tokens, t)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// ParseQuery parses a query, an empty query is nil and matches everything
+func ParseQuery(s string) (Query, error) {
+
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := queryParser{tokens: tokens}
+
+	if p.peek().kind == tokenEOF {
+		return nil, nil
+	}
+
+	q, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &QueryError{t.pos, fmt.Sprintf("Unexpected %s", t)}
+	}
+
+	return q, nil
+}
+
+func (p *queryParser) or() (Query, error) {
+
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		p.next()
+
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = orQuery{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) and() (Query, error) {
+
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek().kind {
+
+		case tokenAnd:
+			p.next()
+
+		// Terms next to each other
+		case tokenWord, tokenNot, tokenLParen:
+
+		default:
+			return left, nil
+		}
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = andQuery{left, right}
+	}
+}
+
+func (p *queryParser) unary() (Query, error) {
+
+	if p.peek().kind == tokenNot {
+		p.next()
+
+		q, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notQuery{q}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *queryParser) primary() (Query, error) {
+
+	t := p.next()
+
+	switch t.kind {
+
+	case tokenLParen:
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, &QueryError{closing.pos, fmt.Sprintf("Expected ) instead of %s", closing)}
+		}
+
+		return q, nil
+
+	case tokenWord:
+		return parseTerm(t)
+	}
+
+	return nil, &QueryError{t.pos, fmt.Sprintf("Unexpected %s", t)}
+}
+
+func parseTerm(t token) (Query, error) {
+
+	if t.quoted {
+		return textQuery{t.text}, nil
+	}
+
+	field, value, found := strings.Cut(t.text, ":")
+	if !found {
+		return textQuery{t.text}, nil
+	}
+
+	var q Query
+
+	switch strings.ToLower(field) {
+
+	case "tag":
+		q = tagQuery{normalizeTag(value)}
+
+	case "site":
+		q = siteQuery{strings.TrimPrefix(strings.ToLower(value), "www.")}
+
+	case "after", "before":
+		date, err := time.ParseInLocation(queryDateFormat, value, time.Local)
+		if err != nil && len(value) > 0 {
+			return nil, &QueryError{t.pos + len(field) + 1,
+				fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", value)}
+		}
+
+		q = dateQuery{date, strings.ToLower(field) == "after"}
+
+	default:
+		// Not a field, e.g. an URL
+		return textQuery{t.text}, nil
+	}
+
+	if len(value) == 0 {
+		return nil, &QueryError{t.pos, fmt.Sprintf("Missing value after %s:", field)}
+	}
+
+	return q, nil
+}
@@ -0,0 +1,108 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+
+	cases := []struct {
+		query  string
+		parsed string
+	}{
+		{`tag:go -tag:old`, `(tag:go AND NOT tag:old)`},
+		{`tag:Go AND site:www.GitHub.com`, `(tag:go AND site:github.com)`},
+		{`a OR b c`, `(a OR (b AND c))`},
+		{`(a OR b) NOT c`, `((a OR b) AND NOT c)`},
+		{`-(a b)`, `NOT (a AND b)`},
+		{`"free text" tag:"machine learning"`, `("free text" AND tag:"machine learning")`},
+		{`after:2024-01-01 before:2024-02-01`, `(after:2024-01-01 AND before:2024-02-01)`},
+		{`https://example.com e-mail or`, `((https://example.com AND e-mail) AND or)`},
+		{`"OR"`, `OR`},
+	}
+
+	for _, c := range cases {
+		q, err := gomark.ParseQuery(c.query)
+		if err != nil {
+			t.Errorf("Error while parsing %s: %v", c.query, err)
+			continue
+		}
+
+		if q.String() != c.parsed {
+			t.Errorf("Error while parsing %s: got %s, expected %s", c.query, q, c.parsed)
+		}
+	}
+
+	if q, err := gomark.ParseQuery("  "); q != nil || err != nil {
+		t.Errorf("Error while parsing an empty query: got %v, %v", q, err)
+	}
+
+	errors := []struct {
+		query    string
+		position int
+	}{
+		{`(a OR b`, 7},
+		{`a OR`, 4},
+		{`a )`, 2},
+		{`AND a`, 0},
+		{`tag:`, 0},
+		{`"unterminated`, 0},
+		{`a after:yesterday`, 8},
+		{`NOT`, 3},
+	}
+
+	for _, e := range errors {
+		_, err := gomark.ParseQuery(e.query)
+
+		queryErr, ok := err.(*gomark.QueryError)
+		if !ok {
+			t.Errorf("Error while parsing %s: got %v", e.query, err)
+			continue
+		}
+
+		if queryErr.Position != e.position {
+			t.Errorf("Error while parsing %s: got %v, expected position %v", e.query, queryErr, e.position)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+
+	b, _ := gomark.NewBookmarkRawUrl("https://blog.github.com/post")
+	b.Title = "Machine learning with Go"
+	b.AddTags("go", "ml")
+	b.Date = time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		query   string
+		matches bool
+	}{
+		{`tag:go`, true},
+		{`tag:go -tag:ml`, false},
+		{`tag:python OR tag:ml`, true},
+		{`site:github.com`, true},
+		{`site:hub.com`, false},
+		{`site:blog.github.com`, true},
+		{`after:2024-03-10`, true},
+		{`after:2024-03-11`, false},
+		{`before:2024-03-10`, false},
+		{`after:2024-01-01 before:2024-04-01`, true},
+		{`"machine learning"`, true},
+		{`machine NOT learning`, false},
+		{`(tag:old OR tag:go) (site:gitlab.com OR "with go")`, true},
+	}
+
+	for _, c := range cases {
+		q, err := gomark.ParseQuery(c.query)
+		if err != nil {
+			t.Errorf("Error while parsing %s: %v", c.query, err)
+			continue
+		}
+
+		if q.Match(b) != c.matches {
+			t.Errorf("Error while matching %s: expected %v", c.query, c.matches)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package gomark
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements never part of the main content of a page
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Menu: true, atom.Button: true, atom.Select: true, atom.Template: true,
+	atom.Iframe: true, atom.Svg: true, atom.Head: true,
+}
+
+// Elements starting a new paragraph of text
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Li: true, atom.Ul: true, atom.Ol: true, atom.Dl: true,
+	atom.Dt: true, atom.Dd: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Pre: true,
+	atom.Blockquote: true, atom.Table: true, atom.Tr: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Br: true, atom.Hr: true,
+}
+
+var (
+	positiveHint = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+	negativeHint = regexp.MustCompile(`(?i)comment|sidebar|footer|masthead|menu|nav|share|social|promo|related|sponsor|advert|banner|cookie|popup`)
+)
+
+// Paragraphs shorter than this are ignored when looking for the content
+const minParagraphLength = 25
+
+// ExtractText returns the title and the text of the main content of a
+// page, found like readability does: the element holding the most text in
+// paragraphs wins, helped by its class and id. Paragraphs are separated by
+// blank lines.
+func ExtractText(doc *html.Node) string {
+
+	scores := make(map[*html.Node]float64)
+	scoreParagraphs(doc, scores)
+
+	var content *html.Node
+	var best float64
+
+	for n, score := range scores {
+		score *= classWeight(n)
+		if score > best {
+			content, best = n, score
+		}
+	}
+
+	if content == nil {
+		content = findElement(doc, atom.Body)
+	}
+
+	var paragraphs []string
+	var title string
+
+	if t := findElement(doc, atom.Title); t != nil {
+		title = collapseSpaces(nodeText(t))
+	}
+
+	if len(title) > 0 {
+		paragraphs = append(paragraphs, title)
+	}
+
+	if content != nil {
+		w := textWriter{}
+		w.node(content)
+		w.flush()
+
+		for _, p := range w.paragraphs {
+			// The heading of the content often repeats the title
+			if len(paragraphs) == 1 && p == title {
+				continue
+			}
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// scoreParagraphs gives the length of each paragraph to its parent, and
+// half of it to its grandparent
+func scoreParagraphs(n *html.Node, scores map[*html.Node]float64) {
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+
+		if c.Type != html.ElementNode || boilerplateElements[c.DataAtom] {
+			continue
+		}
+
+		switch c.DataAtom {
+
+		case atom.P, atom.Pre, atom.Blockquote:
+			length := len(collapseSpaces(nodeText(c)))
+			if length < minParagraphLength || c.Parent == nil {
+				continue
+			}
+
+			// Commas hint at sentences rather than lists of links
+			score := float64(length) + 10*float64(strings.Count(nodeText(c), ","))
+
+			scores[c.Parent] += score
+			if c.Parent.Parent != nil {
+				scores[c.Parent.Parent] += score / 2
+			}
+
+		default:
+			scoreParagraphs(c, scores)
+		}
+	}
+}
+
+func classWeight(n *html.Node) float64 {
+
+	hints := attr(n, "class") + " " + attr(n, "id")
+	weight := 1.0
+
+	if n.DataAtom == atom.Article || n.DataAtom == atom.Main {
+		weight *= 1.5
+	}
+
+	if positiveHint.MatchString(hints) {
+		weight *= 1.25
+	}
+
+	if negativeHint.MatchString(hints) {
+		weight *= 0.5
+	}
+
+	return weight
+}
+
+func nodeText(n *html.Node) string {
+
+	var b strings.Builder
+
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+
+		if n.Type == html.ElementNode && boilerplateElements[n.DataAtom] {
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+
+	visit(n)
+
+	return b.String()
+}
+
+// textWriter splits the text of an element into paragraphs
+type textWriter struct {
+	paragraphs []string
+	current    strings.Builder
+	pre        int
+}
+
+func (w *textWriter) flush() {
+
+	text := w.current.String()
+	w.current.Reset()
+
+	if w.pre == 0 {
+		text = collapseSpaces(text)
+	} else {
+		text = strings.Trim(text, "\n")
+	}
+
+	if len(strings.TrimSpace(text)) > 0 {
+		w.paragraphs = append(w.paragraphs, text)
+	}
+}
+
+func (w *textWriter) node(n *html.Node) {
+
+	switch n.Type {
+
+	case html.TextNode:
+		w.current.WriteString(n.Data)
+		return
+
+	case html.ElementNode:
+		if boilerplateElements[n.DataAtom] {
+			return
+		}
+	}
+
+	block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+
+	if block {
+		w.flush()
+	}
+
+	if n.DataAtom == atom.Pre {
+		w.pre++
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+
+	if block {
+		w.flush()
+	}
+
+	if n.DataAtom == atom.Pre {
+		w.pre--
+	}
+}
@@ -0,0 +1,57 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"golang.org/x/net/html"
+	"strings"
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+
+	cases := []struct {
+		name string
+		doc  string
+		text string
+	}{
+		{"content over sidebar", `<html><head><title>News</title></head><body>
+			<div id="sidebar"><p>Related: a link, another link, a third link, and more links.</p></div>
+			<div class="content"><h2>Big news</h2>
+			<p>The first paragraph of the story tells what happened.</p>
+			<p>The second paragraph, as usual, gives the details.</p></div>
+			<div class="comments"><p>First! This comment is long enough to count.</p></div>
+			</body></html>`,
+			"News\n\nBig news\n\nThe first paragraph of the story tells what happened." +
+				"\n\nThe second paragraph, as usual, gives the details."},
+
+		{"preformatted text", `<html><head><title>Code</title></head><body><article>
+			<p>Here is how to print a line in Go, the classic way.</p>
+			<pre>func main() {
+	fmt.Println("hello")
+}</pre></article></body></html>`,
+			"Code\n\nHere is how to print a line in Go, the classic way." +
+				"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}"},
+
+		{"title repeated as heading", `<html><head><title>Same</title></head><body><main>
+			<h1>Same</h1><p>A paragraph long enough to be the content of the page.</p></main></body></html>`,
+			"Same\n\nA paragraph long enough to be the content of the page."},
+
+		{"no paragraphs", `<html><head><title>Short</title></head><body>
+			<nav>Menu</nav><div>Just <b>a few</b> words</div><br>and a line</body></html>`,
+			"Short\n\nJust a few words\n\nand a line"},
+
+		{"no title", `<p>A lonely paragraph that is long enough to count.</p>`,
+			"A lonely paragraph that is long enough to count."},
+	}
+
+	for _, c := range cases {
+		doc, err := html.Parse(strings.NewReader(c.doc))
+		if err != nil {
+			t.Fatalf("%s: error while parsing: %v", c.name, err)
+		}
+
+		if text := gomark.ExtractText(doc); text != c.text {
+			t.Errorf("%s: got %q, expected %q", c.name, text, c.text)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package gomark
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Resolver gets the information of the pages of a site, usually through
+// its API, better than the generic HTML extraction
+type Resolver interface {
+	// The requests are made by f, DefaultFetcher when nil
+	Resolve(f *Fetcher, u *url.URL) (PageInfo, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(f *Fetcher, u *url.URL) (PageInfo, error)
+
+func (r ResolverFunc) Resolve(f *Fetcher, u *url.URL) (PageInfo, error) {
+	return r(f, u)
+}
+
+// ErrNotResolved is returned by resolvers for the URLs of their site they
+// do not handle, the generic extraction is then used silently
+var ErrNotResolved = errors.New("URL not handled by the resolver")
+
+type resolverEntry struct {
+	pattern  string
+	resolver Resolver
+}
+
+var resolvers struct {
+	sync.RWMutex
+	entries []resolverEntry
+}
+
+// RegisterResolver uses r for the hosts matching pattern, either a host
+// name or "*.domain" matching the domain and all its subdomains. The last
+// resolver registered for a host wins, so the default ones can be
+// replaced.
+func RegisterResolver(pattern string, r Resolver) {
+
+	resolvers.Lock()
+	defer resolvers.Unlock()
+
+	resolvers.entries = append(resolvers.entries, resolverEntry{strings.ToLower(pattern), r})
+}
+
+// ResolverFor returns the resolver registered for the host of u, or nil
+func ResolverFor(u *url.URL) Resolver {
+
+	resolvers.RLock()
+	defer resolvers.RUnlock()
+
+	host := strings.ToLower(u.Hostname())
+
+	for i := len(resolvers.entries) - 1; i >= 0; i-- {
+		if matchHost(resolvers.entries[i].pattern, host) {
+			return resolvers.entries[i].resolver
+		}
+	}
+
+	return nil
+}
+
+func matchHost(pattern string, host string) bool {
+
+	if strings.HasPrefix(pattern, "*.") {
+		domain := pattern[2:]
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+
+	return host == pattern
+}
+
+func init() {
+
+	for _, host := range []string{"youtube.com", "*.youtube.com", "youtu.be"} {
+		RegisterResolver(host, ResolverFunc(GetPageYoutube))
+	}
+
+	RegisterResolver("github.com", &GitHubResolver{APIURL: "https://api.github.com"})
+	RegisterResolver("*.reddit.com", &RedditResolver{})
+	RegisterResolver("*.wikipedia.org", &WikipediaResolver{})
+	RegisterResolver("arxiv.org", &ArxivResolver{APIURL: "https://export.arxiv.org"})
+
+	stackExchange := &StackExchangeResolver{APIURL: "https://api.stackexchange.com"}
+	for _, host := range []string{"stackoverflow.com", "*.stackexchange.com", "superuser.com",
+		"serverfault.com", "askubuntu.com", "mathoverflow.net"} {
+		RegisterResolver(host, stackExchange)
+	}
+
+	RegisterResolver("vimeo.com", &OEmbedResolver{Endpoint: "https://vimeo.com/api/oembed.json"})
+	RegisterResolver("soundcloud.com", &OEmbedResolver{Endpoint: "https://soundcloud.com/oembed"})
+	RegisterResolver("*.flickr.com", &OEmbedResolver{Endpoint: "https://www.flickr.com/services/oembed"})
+}
+
+// getJSON decodes the JSON document at apiUrl into v
+func getJSON(f *Fetcher, apiUrl string, v interface{}) error {
+
+	resp, err := f.Get(apiUrl, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error while getting %s: %s", apiUrl, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// pathParts returns the non empty segments of the path of u
+func pathParts(u *url.URL) []string {
+
+	var parts []string
+	for _, part := range strings.Split(u.Path, "/") {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+
+	return parts
+}
+
+// Descriptions taken from API texts are kept short
+func summarize(text string) string {
+
+	text = collapseSpaces(text)
+
+	runes := []rune(text)
+	if len(runes) > 300 {
+		return string(runes[:299]) + "…"
+	}
+
+	return text
+}
+
+// GitHubResolver handles repositories, issues and pull requests
+type GitHubResolver struct {
+	APIURL string
+}
+
+func (r *GitHubResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	parts := pathParts(u)
+
+	switch {
+
+	case len(parts) == 2:
+		var repo struct {
+			FullName    string `json:"full_name"`
+			Description string `json:"description"`
+		}
+
+		err = getJSON(f, fmt.Sprintf("%s/repos/%s/%s", r.APIURL, parts[0], parts[1]), &repo)
+		page.Title = repo.FullName
+		page.Description = summarize(repo.Description)
+
+	case len(parts) >= 4 && (parts[2] == "issues" || parts[2] == "pull"):
+		var issue struct {
+			Title  string `json:"title"`
+			Number int    `json:"number"`
+			Body   string `json:"body"`
+		}
+
+		// Pull requests are issues for this API
+		err = getJSON(f, fmt.Sprintf("%s/repos/%s/%s/issues/%s", r.APIURL, parts[0], parts[1], parts[3]), &issue)
+
+		kind := "Issue"
+		if parts[2] == "pull" {
+			kind = "Pull Request"
+		}
+
+		page.Title = fmt.Sprintf("%s · %s #%v · %s/%s", issue.Title, kind, issue.Number, parts[0], parts[1])
+		page.Description = summarize(issue.Body)
+
+	default:
+		return page, ErrNotResolved
+	}
+
+	return
+}
+
+// RedditResolver handles posts through the JSON version of their page
+type RedditResolver struct {
+	// Defaults to https://www.reddit.com
+	BaseURL string
+}
+
+func (r *RedditResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	parts := pathParts(u)
+	if len(parts) < 4 || parts[0] != "r" || parts[2] != "comments" {
+		return page, ErrNotResolved
+	}
+
+	base := r.BaseURL
+	if len(base) == 0 {
+		base = "https://www.reddit.com"
+	}
+
+	type post struct {
+		Title     string `json:"title"`
+		Subreddit string `json:"subreddit_name_prefixed"`
+		Selftext  string `json:"selftext"`
+	}
+
+	var listings []struct {
+		Data struct {
+			Children []struct {
+				Data post `json:"data"`
+			} `json:"children"`
+		} `json:"data"`
+	}
+
+	err = getJSON(f, fmt.Sprintf("%s/r/%s/comments/%s.json", base, parts[1], parts[3]), &listings)
+	if err != nil {
+		return
+	}
+
+	if len(listings) == 0 || len(listings[0].Data.Children) == 0 {
+		return page, fmt.Errorf("Reddit post %s not found", parts[3])
+	}
+
+	p := listings[0].Data.Children[0].Data
+	page.Title = fmt.Sprintf("%s : %s", html.UnescapeString(p.Title), p.Subreddit)
+	page.Description = summarize(html.UnescapeString(p.Selftext))
+
+	return
+}
+
+// WikipediaResolver handles articles through the REST summary API of
+// their language edition
+type WikipediaResolver struct {
+	// Defaults to the scheme and host of the resolved URL
+	BaseURL string
+}
+
+func (r *WikipediaResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	parts := pathParts(u)
+	if len(parts) != 2 || parts[0] != "wiki" {
+		return page, ErrNotResolved
+	}
+
+	base := r.BaseURL
+	if len(base) == 0 {
+		base = "https://" + strings.Replace(u.Hostname(), ".m.wikipedia.org", ".wikipedia.org", 1)
+	}
+
+	var summary struct {
+		Title   string `json:"title"`
+		Extract string `json:"extract"`
+	}
+
+	err = getJSON(f, fmt.Sprintf("%s/api/rest_v1/page/summary/%s", base, url.PathEscape(parts[1])), &summary)
+	page.Title = summary.Title + " - Wikipedia"
+	page.Description = summarize(summary.Extract)
+
+	return
+}
+
+// ArxivResolver handles abstracts and PDFs of papers
+type ArxivResolver struct {
+	APIURL string
+}
+
+func (r *ArxivResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	parts := pathParts(u)
+	if len(parts) < 2 || (parts[0] != "abs" && parts[0] != "pdf") {
+		return page, ErrNotResolved
+	}
+
+	// Old identifiers contain a slash, e.g. hep-th/9901001
+	id := strings.TrimSuffix(strings.Join(parts[1:], "/"), ".pdf")
+
+	resp, err := f.Get(fmt.Sprintf("%s/api/query?id_list=%s", r.APIURL, url.QueryEscape(id)), "application/atom+xml")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("Error while getting arXiv paper %s: %s", id, resp.Status)
+	}
+
+	var feed struct {
+		Entries []struct {
+			Title   string `xml:"title"`
+			Summary string `xml:"summary"`
+			Authors []struct {
+				Name string `xml:"name"`
+			} `xml:"author"`
+		} `xml:"entry"`
+	}
+
+	if err = xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return
+	}
+
+	if len(feed.Entries) == 0 || len(feed.Entries[0].Title) == 0 {
+		return page, fmt.Errorf("arXiv paper %s not found", id)
+	}
+
+	entry := feed.Entries[0]
+
+	var authors []string
+	for _, author := range entry.Authors {
+		authors = append(authors, author.Name)
+	}
+
+	page.Title = fmt.Sprintf("[%s] %s", id, collapseSpaces(entry.Title))
+	page.Description = summarize(entry.Summary)
+	page.Preview.Author = strings.Join(authors, ", ")
+
+	return
+}
+
+// StackExchangeResolver handles the questions of Stack Overflow and the
+// other Stack Exchange sites
+type StackExchangeResolver struct {
+	APIURL string
+}
+
+func (r *StackExchangeResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	parts := pathParts(u)
+	if len(parts) < 2 || (parts[0] != "questions" && parts[0] != "q") {
+		return page, ErrNotResolved
+	}
+
+	// The API names sites after their host: stackoverflow, unix.stackexchange...
+	site := strings.TrimSuffix(strings.TrimSuffix(u.Hostname(), ".com"), ".net")
+	site = strings.TrimSuffix(site, ".stackexchange")
+
+	var questions struct {
+		Items []struct {
+			Title string   `json:"title"`
+			Tags  []string `json:"tags"`
+		} `json:"items"`
+	}
+
+	err = getJSON(f, fmt.Sprintf("%s/2.3/questions/%s?site=%s", r.APIURL, url.PathEscape(parts[1]), site), &questions)
+	if err != nil {
+		return
+	}
+
+	if len(questions.Items) == 0 {
+		return page, fmt.Errorf("Question %s not found on %s", parts[1], site)
+	}
+
+	question := questions.Items[0]
+	page.Title = html.UnescapeString(question.Title)
+	page.Description = strings.Join(question.Tags, ", ")
+
+	return
+}
+
+// OEmbedResolver handles the sites providing an oEmbed endpoint
+type OEmbedResolver struct {
+	Endpoint string
+}
+
+func (r *OEmbedResolver) Resolve(f *Fetcher, u *url.URL) (page PageInfo, err error) {
+
+	var embed struct {
+		Title        string `json:"title"`
+		AuthorName   string `json:"author_name"`
+		ProviderName string `json:"provider_name"`
+		ThumbnailUrl string `json:"thumbnail_url"`
+	}
+
+	err = getJSON(f, fmt.Sprintf("%s?format=json&url=%s", r.Endpoint, url.QueryEscape(u.String())), &embed)
+	if err != nil {
+		return
+	}
+
+	if len(embed.Title) == 0 {
+		return page, fmt.Errorf("No title in the oEmbed of %s", u)
+	}
+
+	page.Title = embed.Title
+	if len(embed.AuthorName) > 0 {
+		page.Title = fmt.Sprintf("%s: %s", embed.AuthorName, embed.Title)
+	}
+
+	page.Preview.SiteName = embed.ProviderName
+	page.Preview.Author = embed.AuthorName
+	page.Preview.Image = embed.ThumbnailUrl
+
+	return
+}
@@ -0,0 +1,187 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestResolvers(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/repos/golang/go", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"full_name": "golang/go", "description": "The Go programming language"}`)
+	})
+
+	mux.HandleFunc("/repos/golang/go/issues/42", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"title": "Generics", "number": 42, "body": "Add  generics"}`)
+	})
+
+	mux.HandleFunc("/r/golang/comments/abc.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"data": {"children": [{"data": {"title": "Go &amp; you",
+			"subreddit_name_prefixed": "r/golang", "selftext": "Hello"}}]}}]`)
+	})
+
+	mux.HandleFunc("/api/rest_v1/page/summary/Go_(programming_language)", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"title": "Go (programming language)", "extract": "Go is a programming language."}`)
+	})
+
+	mux.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("id_list") != "1706.03762" {
+			fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom"></feed>`)
+			return
+		}
+
+		fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+			<title>Attention Is All
+			You Need</title>
+			<summary>The dominant sequence transduction models...</summary>
+			<author><name>Ashish Vaswani</name></author>
+			<author><name>Noam Shazeer</name></author>
+		</entry></feed>`)
+	})
+
+	mux.HandleFunc("/2.3/questions/11227809", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("site") != "stackoverflow" {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"items": [{"title": "Why is processing a sorted array faster than an unsorted array?",
+			"tags": ["java", "c++"]}]}`)
+	})
+
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") != "https://vimeo.com/76979871" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, `{"title": "The New Vimeo Player", "author_name": "Vimeo Staff",
+			"provider_name": "Vimeo", "thumbnail_url": "https://i.vimeocdn.com/video/452001751.jpg"}`)
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := []struct {
+		resolver    gomark.Resolver
+		url         string
+		title       string
+		description string
+	}{
+		{&gomark.GitHubResolver{APIURL: ts.URL}, "https://github.com/golang/go",
+			"golang/go", "The Go programming language"},
+		{&gomark.GitHubResolver{APIURL: ts.URL}, "https://github.com/golang/go/issues/42",
+			"Generics · Issue #42 · golang/go", "Add generics"},
+		{&gomark.GitHubResolver{APIURL: ts.URL}, "https://github.com/golang/go/pull/42",
+			"Generics · Pull Request #42 · golang/go", "Add generics"},
+		{&gomark.RedditResolver{BaseURL: ts.URL}, "https://www.reddit.com/r/golang/comments/abc/go_and_you/",
+			"Go & you : r/golang", "Hello"},
+		{&gomark.WikipediaResolver{BaseURL: ts.URL}, "https://en.wikipedia.org/wiki/Go_(programming_language)",
+			"Go (programming language) - Wikipedia", "Go is a programming language."},
+		{&gomark.ArxivResolver{APIURL: ts.URL}, "https://arxiv.org/abs/1706.03762",
+			"[1706.03762] Attention Is All You Need", "The dominant sequence transduction models..."},
+		{&gomark.ArxivResolver{APIURL: ts.URL}, "https://arxiv.org/pdf/1706.03762.pdf",
+			"[1706.03762] Attention Is All You Need", "The dominant sequence transduction models..."},
+		{&gomark.StackExchangeResolver{APIURL: ts.URL},
+			"https://stackoverflow.com/questions/11227809/why-is-processing-a-sorted-array-faster",
+			"Why is processing a sorted array faster than an unsorted array?", "java, c++"},
+		{&gomark.OEmbedResolver{Endpoint: ts.URL + "/oembed"}, "https://vimeo.com/76979871",
+			"Vimeo Staff: The New Vimeo Player", ""},
+	}
+
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+
+		page, err := c.resolver.Resolve(testFetcher(), u)
+		if err != nil {
+			t.Errorf("Error while resolving %s: %s", c.url, err)
+			continue
+		}
+
+		if page.Title != c.title || page.Description != c.description {
+			t.Errorf("Error while resolving %s: got %q, %q", c.url, page.Title, page.Description)
+		}
+	}
+
+	u, _ := url.Parse("https://arxiv.org/abs/1706.03762")
+	page, _ := (&gomark.ArxivResolver{APIURL: ts.URL}).Resolve(testFetcher(), u)
+	if page.Preview.Author != "Ashish Vaswani, Noam Shazeer" {
+		t.Errorf("Error in arXiv authors: got %q", page.Preview.Author)
+	}
+
+	// Missing documents are errors, unknown paths are not handled
+	errors := []struct {
+		resolver gomark.Resolver
+		url      string
+		err      bool
+	}{
+		{&gomark.GitHubResolver{APIURL: ts.URL}, "https://github.com/golang/missing", true},
+		{&gomark.GitHubResolver{APIURL: ts.URL}, "https://github.com/golang", false},
+		{&gomark.ArxivResolver{APIURL: ts.URL}, "https://arxiv.org/abs/0000.00000", true},
+		{&gomark.StackExchangeResolver{APIURL: ts.URL}, "https://superuser.com/questions/11227809", true},
+		{&gomark.StackExchangeResolver{APIURL: ts.URL}, "https://stackoverflow.com/users/1", false},
+		{&gomark.OEmbedResolver{Endpoint: ts.URL + "/oembed"}, "https://vimeo.com/1", true},
+	}
+
+	for _, c := range errors {
+		u, _ := url.Parse(c.url)
+
+		_, err := c.resolver.Resolve(testFetcher(), u)
+		if err == nil || (err == gomark.ErrNotResolved) == c.err {
+			t.Errorf("Error while resolving %s: got error %v", c.url, err)
+		}
+	}
+}
+
+func TestResolverRegistry(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Generic Page</title></head></html>`)
+	}))
+	defer ts.Close()
+
+	gomark.RegisterResolver("*.resolver.test", gomark.ResolverFunc(func(f *gomark.Fetcher, u *url.URL) (gomark.PageInfo, error) {
+		return gomark.PageInfo{Title: "Resolved " + u.Hostname()}, nil
+	}))
+
+	for _, host := range []string{"resolver.test", "www.resolver.test", "a.b.RESOLVER.test"} {
+		u := &url.URL{Scheme: "https", Host: host}
+		if r := gomark.ResolverFor(u); r == nil {
+			t.Errorf("No resolver found for %s", host)
+		}
+	}
+
+	for _, host := range []string{"notresolver.test", "resolver.test.com"} {
+		u := &url.URL{Scheme: "https", Host: host}
+		if r := gomark.ResolverFor(u); r != nil {
+			t.Errorf("Resolver found for %s", host)
+		}
+	}
+
+	u, _ := url.Parse("https://www.resolver.test/page")
+	page, err := gomark.GetPage(testFetcher(), u)
+	if err != nil || page.Title != "Resolved www.resolver.test" {
+		t.Errorf("Error in GetPage with a resolver: got %v, %v", page, err)
+	}
+
+	// The last registration wins, a resolver not handling the URL falls back
+	// to the generic extraction
+	tsUrl, _ := url.Parse(ts.URL)
+	gomark.RegisterResolver(tsUrl.Hostname(), gomark.ResolverFunc(func(f *gomark.Fetcher, u *url.URL) (gomark.PageInfo, error) {
+		return gomark.PageInfo{Title: "First"}, nil
+	}))
+	gomark.RegisterResolver(tsUrl.Hostname(), gomark.ResolverFunc(func(f *gomark.Fetcher, u *url.URL) (gomark.PageInfo, error) {
+		return gomark.PageInfo{}, gomark.ErrNotResolved
+	}))
+
+	page, err = gomark.GetPage(testFetcher(), tsUrl)
+	if err != nil || page.Title != "Generic Page" {
+		t.Errorf("Error in GetPage fallback: got %v, %v", page, err)
+	}
+}
@@ -0,0 +1,121 @@
+package gomark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Version of the document written by Database.Dump, bump it and register a
+// migration whenever the format changes
+const CurrentSchemaVersion = 2
+
+// A migration upgrades, in place, a document from the previous version
+type migration func(doc map[string]json.RawMessage) error
+
+// Migrations indexed by the version they upgrade to
+var migrations = map[int]migration{
+	// Version 0 documents only lack the version field
+	1: func(doc map[string]json.RawMessage) error { return nil },
+	2: migrateBookmarkIDs,
+}
+
+// Bookmarks were indexed by URL before having an ID
+func migrateBookmarkIDs(doc map[string]json.RawMessage) error {
+
+	raw, ok := doc["Bookmarks"]
+	if !ok {
+		return nil
+	}
+
+	var byURL map[string]map[string]json.RawMessage
+	err := json.Unmarshal(raw, &byURL)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]map[string]json.RawMessage, len(byURL))
+	for url, b := range byURL {
+		id := legacyID(url)
+		b["ID"], _ = json.Marshal(id)
+		byID[id] = b
+	}
+
+	doc["Bookmarks"], err = json.Marshal(byID)
+	return err
+}
+
+// legacyID returns the ID of a bookmark stored before bookmarks had one. It
+// is derived from the URL so that it is the same on every load, until the
+// migrated document is dumped, and matches the old journal entries.
+func legacyID(url string) string {
+
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:12])
+}
+
+// SchemaError reports a document written by a newer version of gomark
+type SchemaError struct {
+	Version int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("Schema version %v is newer than the supported version %v, please upgrade gomark",
+		e.Version, CurrentSchemaVersion)
+}
+
+func schemaVersion(doc map[string]json.RawMessage) (version int, err error) {
+
+	raw, ok := doc["SchemaVersion"]
+	if !ok {
+		return 0, nil
+	}
+
+	err = json.Unmarshal(raw, &version)
+	if err != nil {
+		err = fmt.Errorf("Invalid schema version %s: %v", raw, err)
+	}
+
+	return
+}
+
+// migrateDocument upgrades a database document to CurrentSchemaVersion
+func migrateDocument(b []byte) ([]byte, error) {
+
+	var doc map[string]json.RawMessage
+
+	err := json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > CurrentSchemaVersion {
+		return nil, &SchemaError{version}
+	}
+
+	if version == CurrentSchemaVersion {
+		return b, nil
+	}
+
+	for ; version < CurrentSchemaVersion; version++ {
+
+		m, ok := migrations[version+1]
+		if !ok {
+			return nil, fmt.Errorf("No migration to schema version %v", version+1)
+		}
+
+		if err = m(doc); err != nil {
+			return nil, fmt.Errorf("Error in migration to schema version %v: %v", version+1, err)
+		}
+	}
+
+	doc["SchemaVersion"], _ = json.Marshal(CurrentSchemaVersion)
+
+	return json.Marshal(doc)
+}
@@ -0,0 +1,124 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	// A file written before the schema was versioned
+	old := `{"Bookmarks": {"http://example.com": {"Url": "http://example.com", "Title": "Example", "Tags": ["tata"]}}}`
+	ioutil.WriteFile(filename, []byte(old), 0600)
+
+	d, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Error while migrating an unversioned file: %s", err)
+	}
+
+	if d.SchemaVersion != gomark.CurrentSchemaVersion {
+		t.Errorf("Error in migration: got version %v expected %v", d.SchemaVersion, gomark.CurrentSchemaVersion)
+	}
+
+	b, err := d.GetByURL("http://example.com")
+	if err != nil || !b.HasTags("tata") {
+		t.Errorf("Error in migration: bookmark lost (%v)", err)
+	}
+
+	d.Dump()
+
+	dumped, _ := ioutil.ReadFile(filename)
+	if !strings.Contains(string(dumped), fmt.Sprintf(`"SchemaVersion":%v`, gomark.CurrentSchemaVersion)) {
+		t.Errorf("Schema version not written: %s", dumped)
+	}
+
+	// A file from the future
+	future := fmt.Sprintf(`{"SchemaVersion": %v, "Bookmarks": {}}`, gomark.CurrentSchemaVersion+1)
+	ioutil.WriteFile(filename, []byte(future), 0600)
+
+	// Even with a valid older backup available
+	_, err = gomark.NewDatabaseFromFile(filename)
+	if _, ok := err.(*gomark.SchemaError); !ok {
+		t.Errorf("Newer schema not refused: %v", err)
+	}
+
+}
+
+func TestSchemaMigrationReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	old := `{"SchemaVersion": 1, "Bookmarks": {"http://example.com": {"Url": "http://example.com", "Title": "Example", "Tags": ["tata"]}}}`
+	ioutil.WriteFile(filename, []byte(old), 0600)
+
+	d, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Error while migrating a version 1 file: %s", err)
+	}
+
+	// Only written to the journal, the snapshot keeps the old version
+	b, err := d.GetByURL("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.AddTags("toto")
+	if err = d.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copied as after a crash, Close would write a new snapshot
+	copied := filepath.Join(dir, "copy.json")
+	for _, suffix := range []string{"", ".journal"} {
+		data, err := ioutil.ReadFile(filename + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(copied+suffix, data, 0600)
+	}
+	d.Close()
+
+	snapshot, _ := ioutil.ReadFile(copied)
+	if string(snapshot) != old {
+		t.Fatalf("Snapshot written before the reload: %s", snapshot)
+	}
+
+	d, err = gomark.NewDatabaseFromFile(copied)
+	if err != nil {
+		t.Fatalf("Error while reloading a migrated file: %s", err)
+	}
+	defer d.Close()
+
+	books, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 1 {
+		t.Fatalf("Error in migration: got %v bookmarks after reload expected 1", len(books))
+	}
+
+	reloaded, ok := books[b.ID]
+	if !ok || !reloaded.HasTags("tata", "toto") {
+		t.Errorf("Error in migration: journal not replayed on the migrated bookmark %+v", books)
+	}
+
+}
@@ -0,0 +1,598 @@
+package gomark
+
+import (
+	"html"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Default number of hits returned by a search
+const DefaultSearchLimit = 20
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Length in bytes of the context shown around the first match of a snippet
+const (
+	snippetBefore = 60
+	snippetLength = 200
+)
+
+// searchField is a part of a bookmark covered by the index, matches in
+// fields with a higher weight rank first
+type searchField struct {
+	weight float64
+	text   func(b *Bookmark, pageText string) string
+}
+
+var searchFields = []searchField{
+	{3, func(b *Bookmark, _ string) string { return b.Title }},
+	{2, func(b *Bookmark, _ string) string { return strings.Join(b.GetTags(), " ") }},
+	{1.5, func(b *Bookmark, _ string) string { return b.Notes }},
+	{1.5, func(b *Bookmark, _ string) string { return urlText(b) }},
+	{1, func(b *Bookmark, _ string) string { return b.Description }},
+	{0.5, func(_ *Bookmark, pageText string) string { return pageText }},
+}
+
+// urlText keeps the words of the host and path of the URL
+func urlText(b *Bookmark) string {
+	u := b.info.Url
+	return strings.TrimPrefix(u.Hostname(), "www.") + " " + u.Path + " " + u.RawQuery
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "with": true,
+}
+
+// searchToken is a term of the index and where it is in the text
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text in words of letters and digits, lowercased and
+// stemmed. Stop words are skipped.
+func tokenize(text string) []searchToken {
+
+	var tokens []searchToken
+
+	start := -1
+
+	for i := 0; i <= len(text); {
+
+		r, size := utf8.DecodeRuneInString(text[i:])
+		word := i < len(text) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+
+		if word && start < 0 {
+			start = i
+		}
+
+		if !word && start >= 0 {
+			lower := strings.ToLower(text[start:i])
+			if !stopWords[lower] {
+				tokens = append(tokens, searchToken{stem(lower), start, i})
+			}
+			start = -1
+		}
+
+		if i == len(text) {
+			break
+		}
+		i += size
+	}
+
+	return tokens
+}
+
+// stem removes the plurals and the -ed and -ing endings of lowercase
+// English words, as the first step of Porter's algorithm does
+func stem(word string) string {
+
+	if len(word) <= 2 {
+		return word
+	}
+
+	for _, c := range []byte(word) {
+		if c < 'a' || c > 'z' {
+			return word
+		}
+	}
+
+	w := []byte(word)
+
+	// Step 1a
+	switch {
+	case hasSuffix(w, "sses"), hasSuffix(w, "ies"):
+		w = w[:len(w)-2]
+	case hasSuffix(w, "ss"):
+	case hasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+
+	// Step 1b
+	fix := false
+
+	switch {
+	case hasSuffix(w, "eed"):
+		if measure(w[:len(w)-3]) > 0 {
+			w = w[:len(w)-1]
+		}
+	case hasSuffix(w, "ed") && hasVowel(w[:len(w)-2]):
+		w, fix = w[:len(w)-2], true
+	case hasSuffix(w, "ing") && hasVowel(w[:len(w)-3]):
+		w, fix = w[:len(w)-3], true
+	}
+
+	if fix {
+		switch {
+		case hasSuffix(w, "at"), hasSuffix(w, "bl"), hasSuffix(w, "iz"):
+			w = append(w, 'e')
+		case endsDoubleConsonant(w) && !strings.ContainsRune("lsz", rune(w[len(w)-1])):
+			w = w[:len(w)-1]
+		case measure(w) == 1 && endsCVC(w):
+			w = append(w, 'e')
+		}
+	}
+
+	// Step 1c
+	if hasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		w[len(w)-1] = 'i'
+	}
+
+	return string(w)
+}
+
+func hasSuffix(w []byte, suffix string) bool {
+	return strings.HasSuffix(string(w), suffix)
+}
+
+func isConsonant(w []byte, i int) bool {
+
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+
+	return true
+}
+
+func hasVowel(w []byte) bool {
+
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// measure counts the vowel-consonant sequences of w
+func measure(w []byte) (m int) {
+
+	i := 0
+	for i < len(w) && isConsonant(w, i) {
+		i++
+	}
+
+	for i < len(w) {
+		for i < len(w) && !isConsonant(w, i) {
+			i++
+		}
+
+		if i == len(w) {
+			break
+		}
+
+		for i < len(w) && isConsonant(w, i) {
+			i++
+		}
+		m++
+	}
+
+	return
+}
+
+func endsDoubleConsonant(w []byte) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports whether w ends with a consonant, a vowel and a consonant
+// other than w, x or y
+func endsCVC(w []byte) bool {
+	n := len(w)
+	return n >= 3 && isConsonant(w, n-3) && !isConsonant(w, n-2) && isConsonant(w, n-1) &&
+		!strings.ContainsRune("wxy", rune(w[n-1]))
+}
+
+// Index is an inverted index of the bookmarks ranking the matches with
+// BM25, the term frequencies are weighted by the field they appear in.
+// It is safe for concurrent use.
+type Index struct {
+	mutex sync.RWMutex
+	// Weighted frequency of the terms in each bookmark
+	postings    map[string]map[string]float64
+	documents   map[string]indexedDocument
+	totalLength float64
+}
+
+type indexedDocument struct {
+	terms  []string
+	length float64
+}
+
+// IndexHit is a bookmark matching a search of the index
+type IndexHit struct {
+	ID    string
+	Score float64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings:  make(map[string]map[string]float64),
+		documents: make(map[string]indexedDocument),
+	}
+}
+
+// Add indexes the bookmark, replacing its previous version. pageText is
+// the text of its archived page and can be empty.
+func (i *Index) Add(b *Bookmark, pageText string) {
+
+	frequencies := make(map[string]float64)
+	var length float64
+
+	for _, field := range searchFields {
+		for _, token := range tokenize(field.text(b, pageText)) {
+			frequencies[token.term] += field.weight
+			length += field.weight
+		}
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(b.ID)
+
+	document := indexedDocument{length: length}
+
+	for term, frequency := range frequencies {
+		posting, ok := i.postings[term]
+		if !ok {
+			posting = make(map[string]float64)
+			i.postings[term] = posting
+		}
+
+		posting[b.ID] = frequency
+		document.terms = append(document.terms, term)
+	}
+
+	i.documents[b.ID] = document
+	i.totalLength += length
+}
+
+func (i *Index) Remove(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(id)
+}
+
+// remove is the counterpart of Add, the caller must hold the write lock
+func (i *Index) remove(id string) {
+
+	document, ok := i.documents[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range document.terms {
+		delete(i.postings[term], id)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+
+	delete(i.documents, id)
+	i.totalLength -= document.length
+}
+
+// Search returns the IDs of the bookmarks matching any word of text, the
+// best first
+func (i *Index) Search(text string, limit int) []IndexHit {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	n := float64(len(i.documents))
+	if n == 0 {
+		return nil
+	}
+
+	averageLength := i.totalLength / n
+	scores := make(map[string]float64)
+
+	for term := range queryTerms(text) {
+		posting := i.postings[term]
+		df := float64(len(posting))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for id, tf := range posting {
+			norm := 1 - bm25B + bm25B*i.documents[id].length/averageLength
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	hits := make([]IndexHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, IndexHit{id, score})
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].ID < hits[b].ID
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits
+}
+
+func queryTerms(text string) map[string]bool {
+
+	terms := make(map[string]bool)
+	for _, token := range tokenize(text) {
+		terms[token.term] = true
+	}
+
+	return terms
+}
+
+// SearchHit is a bookmark found by a search
+type SearchHit struct {
+	Bookmark Bookmark
+	Score    float64
+	// HTML escaped text around the first match, the matching words are
+	// wrapped in <mark> elements
+	Snippet string
+}
+
+// IndexedStore keeps an index of the bookmarks of a Store up to date. The
+// changes made to the underlying store directly are not indexed.
+type IndexedStore struct {
+	Store
+	Index *Index
+	// Indexes the text of the last snapshot when set
+	Archiver *Archiver
+	// Serializes the changes so the index follows the order of the store
+	mutex sync.Mutex
+}
+
+// NewIndexedStore wraps store, Reindex must be called to index the
+// bookmarks already stored
+func NewIndexedStore(store Store) *IndexedStore {
+	return &IndexedStore{Store: store, Index: NewIndex()}
+}
+
+// Reindex indexes every bookmark of the store
+func (s *IndexedStore) Reindex() error {
+	return s.Store.Iterate(func(b *Bookmark) error {
+		s.Index.Add(b, s.pageText(b))
+		return nil
+	})
+}
+
+func (s *IndexedStore) Put(b *Bookmark) error {
+
+	pageText := s.pageText(b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.Store.Put(b); err != nil {
+		return err
+	}
+
+	s.Index.Add(b, pageText)
+	return nil
+}
+
+func (s *IndexedStore) PutMany(books []*Bookmark) error {
+
+	pageTexts := make([]string, len(books))
+	for i, b := range books {
+		pageTexts[i] = s.pageText(b)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.Store.PutMany(books); err != nil {
+		return err
+	}
+
+	for i, b := range books {
+		s.Index.Add(b, pageTexts[i])
+	}
+
+	return nil
+}
+
+func (s *IndexedStore) Update(id string, fn func(b *Bookmark) error) (*Bookmark, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.Store.Update(id, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Index.Add(b, s.pageText(b))
+	return b, nil
+}
+
+func (s *IndexedStore) UpdateMany(filter func(b *Bookmark) bool, fn func(b *Bookmark) error) (int, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var changed []*Bookmark
+
+	n, err := s.Store.UpdateMany(filter, func(b *Bookmark) error {
+		changed = append(changed, b)
+		return fn(b)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range changed {
+		s.Index.Add(b, s.pageText(b))
+	}
+
+	return n, nil
+}
+
+func (s *IndexedStore) Delete(id string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.Store.Delete(id); err != nil {
+		return err
+	}
+
+	s.Index.Remove(id)
+	return nil
+}
+
+// Search returns at most limit bookmarks matching text, the best first
+func (s *IndexedStore) Search(text string, limit int) ([]SearchHit, error) {
+
+	terms := queryTerms(text)
+	var hits []SearchHit
+
+	for _, hit := range s.Index.Search(text, limit) {
+		b, err := s.Store.Get(hit.ID)
+		if err != nil {
+			// Deleted since the search
+			continue
+		}
+
+		var snippet string
+
+		for _, field := range searchFields {
+			if snippet = highlight(field.text(b, ""), terms); len(snippet) > 0 {
+				break
+			}
+		}
+
+		if len(snippet) == 0 {
+			snippet = highlight(s.pageText(b), terms)
+		}
+
+		hits = append(hits, SearchHit{Bookmark: *b, Score: hit.Score, Snippet: snippet})
+	}
+
+	return hits, nil
+}
+
+func (s *IndexedStore) pageText(b *Bookmark) string {
+
+	if s.Archiver == nil || len(b.Snapshots) == 0 {
+		return ""
+	}
+
+	text, err := s.Archiver.Text(b.Snapshots[len(b.Snapshots)-1])
+	if err != nil {
+		log.Printf("Impossible to index the snapshot of %s: %v", b.GetURL(), err)
+	}
+
+	return text
+}
+
+// highlight returns the text around the first word matching one of the
+// terms, or an empty string when none matches
+func highlight(text string, terms map[string]bool) string {
+
+	tokens := tokenize(text)
+
+	first := -1
+	for i, token := range tokens {
+		if terms[token.term] {
+			first = i
+			break
+		}
+	}
+
+	if first < 0 {
+		return ""
+	}
+
+	// Cut the context on word boundaries
+	from := first
+	for from > 0 && tokens[first].start-tokens[from-1].start <= snippetBefore {
+		from--
+	}
+
+	start := tokens[from].start
+	end := len(text)
+
+	if end-start > snippetLength {
+		end = tokens[first].end
+
+		for _, token := range tokens[first:] {
+			if token.end-start > snippetLength {
+				break
+			}
+			end = token.end
+		}
+	}
+
+	var b strings.Builder
+
+	if from > 0 {
+		b.WriteString("…")
+	}
+
+	last := start
+	for _, token := range tokens[from:] {
+		if token.end > end {
+			break
+		}
+
+		if terms[token.term] {
+			b.WriteString(html.EscapeString(text[last:token.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[token.start:token.end]))
+			b.WriteString("</mark>")
+			last = token.end
+		}
+	}
+
+	b.WriteString(html.EscapeString(text[last:end]))
+
+	if end < len(text) {
+		b.WriteString("…")
+	}
+
+	return collapseSpaces(b.String())
+}
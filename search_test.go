@@ -0,0 +1,134 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"testing"
+)
+
+func TestIndexedStore(t *testing.T) {
+	testStore(t, gomark.NewIndexedStore(gomark.NewDatabase()))
+}
+
+func TestSearch(t *testing.T) {
+
+	db := gomark.NewDatabase()
+
+	old := newTestBookmark(t, "http://example.com/old", "archive")
+	old.Title = "Old notes"
+	old.Notes = "Nothing about the subject here"
+	db.Put(old)
+
+	store := gomark.NewIndexedStore(db)
+	if err := store.Reindex(); err != nil {
+		t.Fatalf("Error while indexing: %v", err)
+	}
+
+	put := func(rawUrl string, title string, notes string, tags ...string) *gomark.Bookmark {
+		b := newTestBookmark(t, rawUrl, tags...)
+		b.Title = title
+		b.Notes = notes
+		if err := store.Put(b); err != nil {
+			t.Fatalf("Error while putting %s: %v", rawUrl, err)
+		}
+		return b
+	}
+
+	testing1 := put("http://example.com/1", "Testing in Go", "Table driven tests & <subtests>", "go")
+	put("http://example.com/python", "Python tips", "Tests with pytest")
+	put("http://example.com/cooking", "Cooking pasta", "Boil the water, then test the pasta")
+
+	search := func(text string) []gomark.SearchHit {
+		hits, err := store.Search(text, 10)
+		if err != nil {
+			t.Fatalf("Error while searching %s: %v", text, err)
+		}
+		return hits
+	}
+
+	hits := search("tested GO")
+	if len(hits) != 3 || hits[0].Bookmark.ID != testing1.ID {
+		t.Fatalf("Error in the ranking: got %v", hits)
+	}
+
+	if hits[0].Snippet != "<mark>Testing</mark> in <mark>Go</mark>" {
+		t.Errorf("Error in the snippet: got %q", hits[0].Snippet)
+	}
+
+	if hits := search("subtests"); len(hits) != 1 || hits[0].Snippet != "Table driven tests &amp; &lt;<mark>subtests</mark>&gt;" {
+		t.Errorf("Error in the notes snippet: got %v", hits)
+	}
+
+	if hits := search("old"); len(hits) != 1 || hits[0].Bookmark.ID != old.ID {
+		t.Errorf("Bookmarks stored before Reindex not found: got %v", hits)
+	}
+
+	if hits := search("the"); len(hits) != 0 {
+		t.Errorf("Stop words searched: got %v", hits)
+	}
+
+	// URLs and tags are indexed too
+	if hits := search("python"); len(hits) != 1 {
+		t.Errorf("Error while searching the URL: got %v", hits)
+	}
+
+	if hits := search("archive"); len(hits) != 1 || hits[0].Bookmark.ID != old.ID {
+		t.Errorf("Error while searching the tags: got %v", hits)
+	}
+
+	// Updates replace the indexed words
+	testing1.Title = "Benchmarks"
+	testing1.Notes = ""
+	store.Put(testing1)
+
+	if hits := search("testing"); len(hits) != 2 {
+		t.Errorf("Error after an update: got %v", hits)
+	}
+
+	if hits := search("benchmark"); len(hits) != 1 || hits[0].Bookmark.ID != testing1.ID {
+		t.Errorf("Error after an update: got %v", hits)
+	}
+
+	store.Delete(testing1.ID)
+
+	if hits := search("benchmark go"); len(hits) != 0 {
+		t.Errorf("Deleted bookmark found: got %v", hits)
+	}
+}
+
+func TestSearchLongText(t *testing.T) {
+
+	db := gomark.NewDatabase()
+	store := gomark.NewIndexedStore(db)
+
+	b := newTestBookmark(t, "http://example.com/long")
+	b.Notes = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor " +
+		"incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud " +
+		"exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure " +
+		"dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. " +
+		"Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt."
+	store.Put(b)
+
+	hits, _ := store.Search("voluptate", 10)
+	if len(hits) != 1 {
+		t.Fatalf("Error while searching: got %v", hits)
+	}
+
+	expected := "…consequat. Duis aute irure dolor in reprehenderit in <mark>voluptate</mark> velit " +
+		"esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non " +
+		"proident, sunt in culpa qui officia deserunt."
+	if hits[0].Snippet != expected {
+		t.Errorf("Error in the snippet: got %q", hits[0].Snippet)
+	}
+
+	hits, _ = store.Search("ipsum", 10)
+	if len(hits) != 1 {
+		t.Fatalf("Error while searching: got %v", hits)
+	}
+
+	expected = "Lorem <mark>ipsum</mark> dolor sit amet, consectetur adipiscing elit, sed do eiusmod " +
+		"tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud " +
+		"exercitation ullamco laboris nisi ut…"
+	if hits[0].Snippet != expected {
+		t.Errorf("Error in the snippet: got %q", hits[0].Snippet)
+	}
+}
@@ -6,15 +6,36 @@ import (
 	"github.com/th3osmith/pure"
 	"log"
 	"net/http"
+	"strings"
 )
 
 type bookmarkHandler struct {
-	database *Database
+	store    Store
+	enricher *Enricher
 }
 
+// BookmarkJSON holds the fields of a bookmark settable by the clients,
+// empty fields are left untouched on update
 type BookmarkJSON struct {
-	Url  string
-	Tags []string
+	Url         string
+	Tags        []string
+	Notes       string
+	Description string
+	// An empty value removes the key
+	Meta map[string]string
+}
+
+func (data BookmarkJSON) applyFields(b *Bookmark) {
+
+	if len(data.Notes) > 0 {
+		b.Notes = data.Notes
+	}
+
+	if len(data.Description) > 0 {
+		b.Description = data.Description
+	}
+
+	b.SetMeta(data.Meta)
 }
 
 func (h bookmarkHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
@@ -24,32 +45,87 @@ func (h bookmarkHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
 
 	data := BookmarkJSON(msg.RequestMap["data"].(BookmarkJSON))
 
-	b, err := NewBookmarkUrl(data.Url)
+	canonical, err := CanonicalURL(data.Url)
 	if err != nil {
-		rww.AddLogMsg(pure.Error, 500, "Impossible to create bookmark")
+		rww.AddLogMsg(pure.Error, 400, "Invalid URL")
+		rww.AddLogMsg(pure.Error, 400, err.Error())
 		rww.Fail()
 		return
 	}
 
-	if len(data.Tags) > 0 {
-		b.AddTags(data.Tags...)
+	// Another spelling of an existing URL only adds its tags to the bookmark
+	b, err := h.store.GetByURL(canonical)
+	merged := err == nil
+
+	apply := func(b *Bookmark) error {
+		if len(data.Tags) > 0 {
+			b.AddTags(data.Tags...)
+		}
+
+		data.applyFields(b)
+		return nil
 	}
 
-	h.database.AddBookmark(b)
+	if merged {
+		b, err = h.store.Update(b.ID, apply)
+	} else {
+		// The page is fetched once the bookmark is stored
+		b, err = NewBookmarkRawUrl(data.Url)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, "Impossible to create bookmark")
+			rww.Fail()
+			return
+		}
+
+		apply(b)
+		err = h.store.Put(b)
+	}
+
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to store bookmark")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	if !merged && !h.enricher.Enqueue(b.ID) {
+		rww.AddLogMsg(pure.Info, 200, "Too many pages to fetch, the URL is kept as title")
+	}
 
 	result := make(map[string]Bookmark)
-	result[data.Url] = *b
+	result[b.ID] = *b
 	rww.AddValue("result", result)
+	rww.AddValue("merged", merged)
 
-	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Created Bookmark for %s", data.Url))
-	err = h.database.Dump()
-	if err != nil {
-		rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to Dump DB"))
+	if merged {
+		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Merged %s into the Bookmark for %s", data.Url, b.GetURL()))
+	} else {
+		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Created Bookmark for %s", data.Url))
 	}
 
 	return
 }
 
+// lookup finds the bookmark addressed by the id or, failing that, the url
+// of the request
+func (h bookmarkHandler) lookup(msg pure.PureMsg) (*Bookmark, error) {
+
+	if id, ok := msg.RequestMap["id"].(string); ok && len(id) > 0 {
+		return h.store.Get(id)
+	}
+
+	url, _ := msg.RequestMap["url"].(string)
+
+	if canonical, err := CanonicalURL(url); err == nil {
+		if b, err := h.store.GetByURL(canonical); err == nil {
+			return b, nil
+		}
+	}
+
+	// Bookmarks stored before URLs were canonicalized
+	return h.store.GetByURL(url)
+}
+
 func (h bookmarkHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
 
 	rww := rw.(*pure.PureResponseWriter)
@@ -58,9 +134,8 @@ func (h bookmarkHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
 	data, dataOk := msg.RequestMap["data"].(BookmarkJSON)
 	add_tmp, add_ok := msg.RequestMap["add_tags"]
 	del_tmp, del_ok := msg.RequestMap["del_tags"]
-	url := msg.RequestMap["url"].(string)
 
-	b, err := h.database.GetBookmark(url)
+	b, err := h.lookup(msg)
 	if err != nil {
 		rww.AddLogMsg(pure.Error, 500, "Impossible to get bookmark")
 		rww.AddLogMsg(pure.Error, 500, err.Error())
@@ -68,30 +143,63 @@ func (h bookmarkHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
 		return
 	}
 
-	if dataOk && len(data.Tags) > 0 {
-		b.ResetTags()
-		b.AddTags(data.Tags...)
-	}
+	// Set when the new URL is refused
+	var urlErr error
+	urlChanged := false
+
+	b, err = h.store.Update(b.ID, func(b *Bookmark) error {
 
-	if add_ok {
-		b.AddTags(add_tmp.([]string)...)
+		if dataOk && len(data.Url) > 0 && data.Url != b.RawUrl {
+			if urlErr = b.SetURL(data.Url); urlErr != nil {
+				return urlErr
+			}
+			urlChanged = true
+		}
+
+		if dataOk && len(data.Tags) > 0 {
+			b.ResetTags()
+			b.AddTags(data.Tags...)
+		}
+
+		if dataOk {
+			data.applyFields(b)
+		}
+
+		if add_ok {
+			b.AddTags(add_tmp.([]string)...)
+		}
+
+		if del_ok {
+			b.DeleteTags(del_tmp.([]string)...)
+		}
+
+		return nil
+	})
+
+	if urlErr != nil {
+		rww.AddLogMsg(pure.Error, 400, "Invalid URL")
+		rww.AddLogMsg(pure.Error, 400, urlErr.Error())
+		rww.Fail()
+		return
 	}
 
-	if del_ok {
-		b.DeleteTags(del_tmp.([]string)...)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to store bookmark")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
 	}
 
-	h.database.AddBookmark(b)
+	// The page of the old URL is dropped if it is being fetched
+	if urlChanged && !h.enricher.Enqueue(b.ID) {
+		rww.AddLogMsg(pure.Info, 200, "Too many pages to fetch, the page of the new URL is not fetched")
+	}
 
 	result := make(map[string]Bookmark)
-	result[url] = *b
+	result[b.ID] = *b
 	rww.AddValue("result", result)
 
-	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Updated Bookmark for %s", data.Url))
-	err = h.database.Dump()
-	if err != nil {
-		rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to Dump DB"))
-	}
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Updated Bookmark for %s", b.GetURL()))
 
 	return
 }
@@ -101,9 +209,7 @@ func (h bookmarkHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
 	rww := rw.(*pure.PureResponseWriter)
 	msg := m.Msg
 
-	url := msg.RequestMap["url"].(string)
-
-	b, err := h.database.GetBookmark(url)
+	b, err := h.lookup(msg)
 	if err != nil {
 		rww.AddLogMsg(pure.Error, 500, "Impossible to get bookmark")
 		rww.AddLogMsg(pure.Error, 500, err.Error())
@@ -111,45 +217,150 @@ func (h bookmarkHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
 		return
 	}
 
-	h.database.DeleteBookmark(b)
+	err = h.store.Delete(b.ID)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to delete bookmark")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
 
 	result := make(map[string]Bookmark)
-	result[url] = *b
+	result[b.ID] = *b
 	rww.AddValue("result", result)
 
-	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Deleted Bookmark for %s", url))
-	err = h.database.Dump()
-	if err != nil {
-		rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to Dump DB"))
-	}
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Deleted Bookmark for %s", b.GetURL()))
 
 	return
 
 }
+
+// Retrieve returns the bookmark with the given id or url, or a page of the
+// bookmarks matching the filters. The results are a list, limited to the
+// requested fields.
 func (h bookmarkHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
 
 	rww := rw.(*pure.PureResponseWriter)
 	msg := m.Msg
 
-	url := msg.RequestMap["url"].(string)
+	id, _ := msg.RequestMap["id"].(string)
+	url, _ := msg.RequestMap["url"].(string)
+	text, _ := msg.RequestMap["text"].(string)
+	state, _ := msg.RequestMap["state"].(string)
+	health, _ := msg.RequestMap["health"].(string)
+	rawQuery, _ := msg.RequestMap["query"].(string)
+	rawFields, _ := msg.RequestMap["fields"].([]string)
+
+	page := PageRequest{}
+	page.Sort, _ = msg.RequestMap["sort"].(string)
+	page.Limit, _ = msg.RequestMap["limit"].(int)
+	page.Cursor, _ = msg.RequestMap["cursor"].(string)
+
+	switch order, _ := msg.RequestMap["order"].(string); order {
+	case "", "asc":
+	case "desc":
+		page.Descending = true
+	default:
+		rww.AddLogMsg(pure.Error, 400, fmt.Sprintf("Unknown order %s", order))
+		rww.Fail()
+		return
+	}
 
-	result := make(map[string]Bookmark)
+	healthFilter, ok := healthFilters[health]
+	if len(health) > 0 && !ok {
+		rww.AddLogMsg(pure.Error, 400, fmt.Sprintf("Unknown health %s", health))
+		rww.Fail()
+		return
+	}
 
-	if len(url) == 0 {
-		result = h.database.GetBookmarks()
-		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Retrieved all Bookmarks"))
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 400, err.Error())
+		rww.AddValue("error", err)
+		rww.Fail()
+		return
+	}
+
+	fields, err := projectionFields(rawFields)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 400, err.Error())
+		rww.Fail()
+		return
+	}
+
+	var books []Bookmark
+
+	if len(id) == 0 && len(url) == 0 {
+		all, err := selectBookmarks(h.store, query)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, "Impossible to list bookmarks")
+			rww.AddLogMsg(pure.Error, 500, err.Error())
+			rww.Fail()
+			return
+		}
+
+		for _, b := range all {
+			if len(text) > 0 && !b.Matches(text) {
+				continue
+			}
+
+			if len(state) > 0 && string(b.GetState()) != state {
+				continue
+			}
+
+			if healthFilter != nil && !healthFilter(b.Health) {
+				continue
+			}
+
+			if query != nil && !query.Match(&b) {
+				continue
+			}
+
+			books = append(books, b)
+		}
+
+		total := len(books)
+
+		var next string
+		books, next, err = page.Page(books)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 400, err.Error())
+			rww.Fail()
+			return
+		}
+
+		rww.AddValue("next_cursor", next)
+		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Retrieved %v of %v Bookmarks", len(books), total))
 
 	} else {
-		b, err := h.database.GetBookmark(url)
+		b, err := h.lookup(msg)
 		if err != nil {
 			rww.AddLogMsg(pure.Error, 500, "Impossible to get bookmark")
 			rww.AddLogMsg(pure.Error, 500, err.Error())
 			rww.Fail()
 			return
 		}
-		result[url] = *b
-		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Retrieved Bookmark for %s", url))
+		books = append(books, *b)
+		rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Retrieved Bookmark for %s", b.GetURL()))
+
+	}
+
+	// Every field is returned by default
+	if len(rawFields) == 0 {
+		rww.AddValue("result", books)
+		return
+	}
+
+	result := make([]map[string]json.RawMessage, 0, len(books))
 
+	for i := range books {
+		projection, err := books[i].project(fields)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to encode bookmark %s", books[i].ID))
+			rww.Fail()
+			return
+		}
+		result = append(result, projection)
 	}
 
 	rww.AddValue("result", result)
@@ -159,13 +370,425 @@ func (h bookmarkHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
 func (h bookmarkHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
 }
 
+// stateHandler moves bookmarks through the reading queue
+type stateHandler struct {
+	store Store
+}
+
+func (h stateHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "create", "state")
+}
+
+func (h stateHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "delete", "state")
+}
+
+// Update sets the state of every bookmark listed in ids
+func (h stateHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	msg := m.Msg
+
+	ids, _ := msg.RequestMap["ids"].([]string)
+	rawState, _ := msg.RequestMap["state"].(string)
+
+	state, err := ParseState(rawState)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 400, err.Error())
+		rww.Fail()
+		return
+	}
+
+	result := make(map[string]Bookmark)
+
+	for _, id := range ids {
+		b, err := h.store.Update(id, func(b *Bookmark) error {
+			b.SetState(state)
+			return nil
+		})
+
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to mark %s as %s: %v", id, state, err))
+			continue
+		}
+
+		result[b.ID] = *b
+	}
+
+	if len(result) == 0 && len(ids) > 0 {
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", result)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Marked %v Bookmarks as %s", len(result), state))
+}
+
+// Retrieve counts the bookmarks in each state
+func (h stateHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+
+	result := map[BookmarkState]int{StateUnread: 0, StateRead: 0, StateArchived: 0}
+
+	err := h.store.Iterate(func(b *Bookmark) error {
+		result[b.GetState()]++
+		return nil
+	})
+
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to list bookmarks")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", result)
+	rww.AddLogMsg(pure.Info, 200, "Retrieved Bookmark states")
+}
+
+func (h stateHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
+}
+
+// checkHandler checks that the URLs of the bookmarks still work
+type checkHandler struct {
+	store   Store
+	checker *Checker
+}
+
+func (h checkHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "create", "check")
+}
+
+func (h checkHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "delete", "check")
+}
+
+// Update checks the bookmarks listed in ids, or starts checking every
+// bookmark in the background without ids
+func (h checkHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	msg := m.Msg
+
+	ids, _ := msg.RequestMap["ids"].([]string)
+
+	if len(ids) == 0 {
+		if h.checker.Running() {
+			rww.AddLogMsg(pure.Error, 409, "A check is already running")
+			rww.Fail()
+			return
+		}
+
+		go func() {
+			n, err := h.checker.CheckAll()
+			if err != nil {
+				log.Printf("Impossible to check the bookmarks: %v", err)
+				return
+			}
+			log.Printf("Checked %v bookmarks", n)
+		}()
+
+		rww.AddLogMsg(pure.Info, 200, "Checking every Bookmark")
+		return
+	}
+
+	result := make(map[string]Bookmark)
+
+	for _, id := range ids {
+		b, err := h.checker.Check(id)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to check %s: %v", id, err))
+			continue
+		}
+
+		result[b.ID] = *b
+	}
+
+	if len(result) == 0 {
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", result)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Checked %v Bookmarks", len(result)))
+}
+
+// Retrieve counts the bookmarks matching each health filter
+func (h checkHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+
+	result := make(map[string]int)
+	for name := range healthFilters {
+		result[name] = 0
+	}
+
+	err := h.store.Iterate(func(b *Bookmark) error {
+		for name, filter := range healthFilters {
+			if filter(b.Health) {
+				result[name]++
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to list bookmarks")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", result)
+	rww.AddValue("running", h.checker.Running())
+	rww.AddLogMsg(pure.Info, 200, "Retrieved Bookmark health")
+}
+
+func (h checkHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
+}
+
+// archiveHandler stores snapshots of the bookmarked pages
+type archiveHandler struct {
+	store    Store
+	archiver *Archiver
+}
+
+func (h archiveHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "create", "archive")
+}
+
+func (h archiveHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "delete", "archive")
+}
+
+func (h archiveHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "retrieve", "archive")
+}
+
+// Update archives the pages of the bookmarks listed in ids
+func (h archiveHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	msg := m.Msg
+
+	if h.archiver == nil {
+		rww.AddLogMsg(pure.Error, 501, "Archiving is disabled")
+		rww.Fail()
+		return
+	}
+
+	ids, _ := msg.RequestMap["ids"].([]string)
+	result := make(map[string]Bookmark)
+
+	for _, id := range ids {
+		b, err := h.archiver.ArchiveBookmark(h.store, id)
+		if err != nil {
+			rww.AddLogMsg(pure.Error, 500, fmt.Sprintf("Impossible to archive %s: %v", id, err))
+			continue
+		}
+
+		result[b.ID] = *b
+	}
+
+	if len(result) == 0 {
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", result)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Archived %v Bookmarks", len(result)))
+}
+
+func (h archiveHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
+}
+
+// tagHandler manages the tags across every bookmark
+type tagHandler struct {
+	store Store
+}
+
+func (h tagHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "create", "tag")
+}
+
+// tags returns the tags of the request, given in tag or tags
+func (h tagHandler) tags(msg pure.PureMsg) []string {
+
+	tags, _ := msg.RequestMap["tags"].([]string)
+	if tag, _ := msg.RequestMap["tag"].(string); len(tag) > 0 {
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// Update renames a tag, or merges several, into new_tag. Their descendants
+// move along.
+func (h tagHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	msg := m.Msg
+
+	tags := h.tags(msg)
+	newTag, _ := msg.RequestMap["new_tag"].(string)
+
+	if len(tags) == 0 || len(strings.TrimSpace(newTag)) == 0 {
+		rww.AddLogMsg(pure.Error, 400, "Tags and new tag needed")
+		rww.Fail()
+		return
+	}
+
+	n, err := MergeTags(h.store, tags, newTag)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to update tags")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", n)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Tagged %v Bookmarks with %s", n, newTag))
+}
+
+// Delete removes the tags and their descendants from every bookmark
+func (h tagHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+
+	tags := h.tags(m.Msg)
+	if len(tags) == 0 {
+		rww.AddLogMsg(pure.Error, 400, "No tag given")
+		rww.Fail()
+		return
+	}
+
+	n, err := DeleteTag(h.store, tags...)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to delete tags")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", n)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Removed tags from %v Bookmarks", n))
+}
+
+// Retrieve returns the tree of the tags with the number of bookmarks using
+// each of them
+func (h tagHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+
+	tree, err := TagTree(h.store)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to list tags")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", tree)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Retrieved %v top level Tags", len(tree)))
+}
+
+func (h tagHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
+}
+
+// searchHandler looks for bookmarks in the full-text index
+type searchHandler struct {
+	index *IndexedStore
+}
+
+func (h searchHandler) Create(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "create", "search")
+}
+
+func (h searchHandler) Update(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "update", "search")
+}
+
+func (h searchHandler) Delete(m pure.PureReq, rw pure.ResponseWriter) {
+	unsupported(rw, "delete", "search")
+}
+
+// Retrieve returns the bookmarks matching text, ranked, with a snippet
+func (h searchHandler) Retrieve(m pure.PureReq, rw pure.ResponseWriter) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	msg := m.Msg
+
+	text, _ := msg.RequestMap["text"].(string)
+	limit, _ := msg.RequestMap["limit"].(int)
+
+	if len(strings.TrimSpace(text)) == 0 {
+		rww.AddLogMsg(pure.Error, 400, "Nothing to search")
+		rww.Fail()
+		return
+	}
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	hits, err := h.index.Search(text, limit)
+	if err != nil {
+		rww.AddLogMsg(pure.Error, 500, "Impossible to search bookmarks")
+		rww.AddLogMsg(pure.Error, 500, err.Error())
+		rww.Fail()
+		return
+	}
+
+	rww.AddValue("result", hits)
+	rww.AddLogMsg(pure.Info, 200, fmt.Sprintf("Found %v Bookmarks", len(hits)))
+}
+
+func (h searchHandler) Flush(m pure.PureReq, rw pure.ResponseWriter) {
+}
+
+func unsupported(rw pure.ResponseWriter, action string, dataType string) {
+
+	rww := rw.(*pure.PureResponseWriter)
+	rww.AddLogMsg(pure.Error, 400, fmt.Sprintf("Action %s not supported on %s", action, dataType))
+	rww.Fail()
+}
+
 type Server struct {
 	Muxer   *pure.PureMux
 	Handler *bookmarkHandler
+	// Events pushed to the clients of /events
+	Events   *EventHub
+	Enricher *Enricher
+	// Fetches the pages of the new bookmarks and, unless a Checker is set,
+	// checks the URLs. DefaultFetcher when nil.
+	Fetcher *Fetcher
+	// A Checker set before calling Serve is used by the check handler
+	Checker *Checker
+	// Archiving is disabled without Archiver, the snapshots are served on
+	// /archive/
+	Archiver        *Archiver
+	ArchiveOnCreate bool
+	// Full-text index of the bookmarks, searched by the search handler
+	Index *IndexedStore
 }
 
 type RequestMap struct {
+	ID      string       `json:"id"`
 	Url     string       `json:"url"`
+	Text    string       `json:"text"`
+	IDs     []string     `json:"ids"`
+	State   string       `json:"state"`
+	Health  string       `json:"health"`
+	Query   string       `json:"query"`
+	Limit   int          `json:"limit"`
+	Cursor  string       `json:"cursor"`
+	Sort    string       `json:"sort"`
+	Order   string       `json:"order"`
+	Fields  []string     `json:"fields"`
+	Tag     string       `json:"tag"`
+	Tags    []string     `json:"tags"`
+	NewTag  string       `json:"new_tag"`
 	Data    BookmarkJSON `json:"data"`
 	AddTags []string     `json:"add_tags"`
 	DelTags []string     `json:"del_tags"`
@@ -183,7 +806,21 @@ func DecodeRequestMap(p json.RawMessage) (err error, out map[string]interface{})
 
 	out["add_tags"] = rm.AddTags
 	out["del_tags"] = rm.DelTags
+	out["id"] = rm.ID
 	out["url"] = rm.Url
+	out["text"] = rm.Text
+	out["ids"] = rm.IDs
+	out["state"] = rm.State
+	out["health"] = rm.Health
+	out["query"] = rm.Query
+	out["limit"] = rm.Limit
+	out["cursor"] = rm.Cursor
+	out["sort"] = rm.Sort
+	out["order"] = rm.Order
+	out["fields"] = rm.Fields
+	out["tag"] = rm.Tag
+	out["tags"] = rm.Tags
+	out["new_tag"] = rm.NewTag
 	out["data"] = rm.Data
 
 	return
@@ -221,11 +858,16 @@ type Authenticator interface {
 	CheckCredentials(username string, password string) bool
 }
 
-func ServeHttp(db *Database, server *Server, host string, port int, config HttpConfig) {
+func ServeHttp(store Store, server *Server, host string, port int, config HttpConfig) {
 
-	Serve(db, server, config.Authenticator)
+	Serve(store, server, config.Authenticator)
 
 	http.Handle("/pure", pure.WebsocketHandler(*server.Muxer, DecodeRequestMap))
+	http.Handle("/events", server.Events.Handler(config.Authenticator))
+
+	if server.Archiver != nil {
+		http.Handle("/archive/", http.StripPrefix("/archive", server.Archiver.Handler(config.Authenticator)))
+	}
 
 	var err error
 
@@ -239,22 +881,58 @@ func ServeHttp(db *Database, server *Server, host string, port int, config HttpC
 
 }
 
-func Serve(db *Database, server *Server, authenticator Authenticator) {
+func Serve(store Store, server *Server, authenticator Authenticator) {
 
 	mux := pure.NewPureMux()
 
-	h := bookmarkHandler{db}
+	// Every change goes through the index
+	index := NewIndexedStore(store)
+	index.Archiver = server.Archiver
+	if err := index.Reindex(); err != nil {
+		log.Printf("Impossible to index the bookmarks: %v", err)
+	}
+	store = index
+
+	events := NewEventHub()
+	enricher := NewEnricher(store, server.Fetcher, events, DefaultEnrichWorkers, DefaultEnrichQueue)
+	if server.ArchiveOnCreate {
+		enricher.Archiver = server.Archiver
+	}
+
+	if server.Checker == nil {
+		server.Checker = NewChecker(store, server.Fetcher, DefaultCheckHostInterval)
+	}
+
+	h := bookmarkHandler{store, enricher}
+	sh := stateHandler{store}
+	ch := checkHandler{store, server.Checker}
+	ah := archiveHandler{store, server.Archiver}
+	srh := searchHandler{index}
+	th := tagHandler{store}
 
 	if authenticator != nil {
 		am := authMiddleware{authenticator}
 		hb := pure.AddMiddleware(h, am.Auth)
 		mux.RegisterHandler("bookmark", hb)
+		mux.RegisterHandler("state", pure.AddMiddleware(sh, am.Auth))
+		mux.RegisterHandler("check", pure.AddMiddleware(ch, am.Auth))
+		mux.RegisterHandler("archive", pure.AddMiddleware(ah, am.Auth))
+		mux.RegisterHandler("search", pure.AddMiddleware(srh, am.Auth))
+		mux.RegisterHandler("tag", pure.AddMiddleware(th, am.Auth))
 
 	} else {
 		mux.RegisterHandler("bookmark", h)
+		mux.RegisterHandler("state", sh)
+		mux.RegisterHandler("check", ch)
+		mux.RegisterHandler("archive", ah)
+		mux.RegisterHandler("search", srh)
+		mux.RegisterHandler("tag", th)
 	}
 
 	server.Muxer = mux
 	server.Handler = &h
+	server.Events = events
+	server.Enricher = enricher
+	server.Index = index
 
 }
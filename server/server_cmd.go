@@ -7,32 +7,74 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 )
 
 type config struct {
-	UseTLS      bool
-	Certificate string
-	Key         string
-	Port        int
-	Host        string
-	DbFile      string
-	Username    string
-	Password    string
-	YoutubeKey  string
+	UseTLS            bool
+	Certificate       string
+	Key               string
+	Port              int
+	Host              string
+	DbBackend         string
+	DbFile            string
+	DbBackups         int
+	DbJournalLimit    int64
+	DbCompactInterval int
+	Username          string
+	Password          string
+	YoutubeKey        string
+	Canonicalizer     *gomark.Canonicalizer
+	// Settings of the page fetching, the timeout is in seconds
+	FetchTimeout        int
+	FetchMaxBodySize    int64
+	FetchMaxRedirects   int
+	FetchUserAgent      string
+	FetchProxy          string
+	FetchAllowedSchemes []string
+	// Private networks, addresses or host names the server can fetch
+	FetchAllowedNetworks []string
+	// Seconds between two checks of every bookmark URL, 0 disables them
+	CheckInterval int
+	// Snapshots are stored in ArchiveDir, by default the archive directory
+	// next to DbFile
+	Archive         bool
+	ArchiveDir      string
+	ArchiveOnCreate bool
 }
 
 func getDefaultConfig() config {
 
+	// Settings missing from the config file keep their default value
+	canonicalizer := gomark.DefaultCanonicalizer
+	fetcher := gomark.DefaultFetcher
+
 	return config{
 		false,
 		"",
 		"",
 		3000,
 		"127.0.0.1",
+		"json",
+		"",
+		gomark.DefaultBackups,
+		gomark.DefaultJournalLimit,
+		0,
 		"",
 		"",
 		"",
+		&canonicalizer,
+		int(fetcher.Timeout / time.Second),
+		fetcher.MaxBodySize,
+		fetcher.MaxRedirects,
+		fetcher.UserAgent,
+		fetcher.Proxy,
+		fetcher.AllowedSchemes,
+		fetcher.AllowedNetworks,
+		0,
+		false,
 		"",
+		false,
 	}
 }
 
@@ -78,14 +120,7 @@ func main() {
 
 	home := os.Getenv("HOME")
 
-	if len(c.DbFile) == 0 {
-		c.DbFile = home + "/.gomark/db.json"
-	}
-
-	err := checkDbFile(c.DbFile)
-	checkFatal(err, "Checking DB File")
-
-	db, err := gomark.NewDatabaseFromFile(c.DbFile)
+	store, err := openStore(c, home)
 	checkFatal(err, "Creating DB")
 
 	var server gomark.Server
@@ -102,9 +137,92 @@ func main() {
 		gomark.YoutubeKey = c.YoutubeKey
 	}
 
+	if c.Canonicalizer != nil {
+		gomark.URLCanonicalizer = *c.Canonicalizer
+	}
+
+	server.Fetcher = &gomark.Fetcher{
+		Timeout:         time.Duration(c.FetchTimeout) * time.Second,
+		MaxBodySize:     c.FetchMaxBodySize,
+		MaxRedirects:    c.FetchMaxRedirects,
+		UserAgent:       c.FetchUserAgent,
+		Proxy:           c.FetchProxy,
+		AllowedSchemes:  c.FetchAllowedSchemes,
+		AllowedNetworks: c.FetchAllowedNetworks,
+	}
+
+	server.Checker = gomark.NewChecker(store, server.Fetcher, gomark.DefaultCheckHostInterval)
+	if c.CheckInterval > 0 {
+		server.Checker.CheckEvery(time.Duration(c.CheckInterval) * time.Second)
+	}
+
+	if c.Archive {
+		if len(c.ArchiveDir) == 0 {
+			c.ArchiveDir = path.Join(path.Dir(dbFile(c, home)), "archive")
+		}
+
+		server.Archiver, err = gomark.NewArchiver(c.ArchiveDir, server.Fetcher)
+		checkFatal(err, "Creating archive")
+		server.ArchiveOnCreate = c.ArchiveOnCreate
+	}
+
 	fmt.Printf("Gomark Sever starting on port %v\n", c.Port)
-	gomark.ServeHttp(db, &server, c.Host, c.Port, config)
+	gomark.ServeHttp(store, &server, c.Host, c.Port, config)
+
+}
+
+// dbFile returns the database file, with its default for the backend
+func dbFile(c config, home string) string {
+
+	if len(c.DbFile) > 0 {
+		return c.DbFile
+	}
+
+	if c.DbBackend == "sqlite" {
+		return home + "/.gomark/db.sqlite"
+	}
+
+	return home + "/.gomark/db.json"
+}
+
+func openStore(c config, home string) (gomark.Store, error) {
+
+	c.DbFile = dbFile(c, home)
+
+	switch c.DbBackend {
 
+	case "json":
+
+		// The file is created by the first dump
+		err := checkDbDir(c.DbFile)
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := gomark.NewDatabaseFromFile(c.DbFile)
+		if err != nil {
+			return nil, err
+		}
+
+		db.Backups = c.DbBackups
+		db.JournalLimit = c.DbJournalLimit
+
+		if c.DbCompactInterval > 0 {
+			db.CompactEvery(time.Duration(c.DbCompactInterval) * time.Second)
+		}
+
+		return db, nil
+
+	case "sqlite":
+		err := checkDbDir(c.DbFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return gomark.NewSQLiteStore(c.DbFile)
+	}
+
+	return nil, fmt.Errorf("Unknown DB backend %s", c.DbBackend)
 }
 
 func checkFatal(err error, context string) {
@@ -114,7 +232,7 @@ func checkFatal(err error, context string) {
 	}
 }
 
-func checkDbFile(pathString string) error {
+func checkDbDir(pathString string) error {
 
 	dir := path.Dir(pathString)
 
@@ -126,14 +244,5 @@ func checkDbFile(pathString string) error {
 		}
 	}
 
-	// Create the file if not existent
-	if _, err := os.Stat(pathString); os.IsNotExist(err) {
-		file, err := os.Create(pathString)
-		if err != nil {
-			return err
-		}
-		file.Close()
-	}
-
 	return nil
 }
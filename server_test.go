@@ -2,12 +2,19 @@ package gomark_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/th3osmith/gomark"
 	"github.com/th3osmith/pure"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestWebsocketServer(t *testing.T) {
@@ -15,7 +22,7 @@ func TestWebsocketServer(t *testing.T) {
 	db := gomark.NewDatabase()
 
 	var server gomark.Server
-	go gomark.ServeHttp(db, &server, 3000, gomark.HttpConfig{})
+	go gomark.ServeHttp(db, &server, "localhost", 3000, gomark.HttpConfig{})
 
 	u := url.URL{Scheme: "ws", Host: "localhost:3000", Path: "/pure"}
 
@@ -58,7 +65,7 @@ func TestWebsocketServer(t *testing.T) {
 	}()
 
 	mm := make(map[string]interface{})
-	data := gomark.BookmarkJSON{"http://google.com", []string{"tata", "yoyo"}}
+	data := gomark.BookmarkJSON{Url: "http://google.com", Tags: []string{"tata", "yoyo"}}
 	mm["data"] = data
 
 	// Creating Request
@@ -81,14 +88,14 @@ func TestServer(t *testing.T) {
 
 	mm := make(map[string]interface{})
 
-	data := gomark.BookmarkJSON{"http://google.com", []string{"tata", "yoyo"}}
+	data := gomark.BookmarkJSON{Url: "http://google.com", Tags: []string{"tata", "yoyo"}}
 	mm["data"] = data
 
 	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "create", RequestMap: mm})
 	resp := c1.ReadResp()
 
 	if resp.Action != "CREATED" {
-		t.Errorf("Error in the creation of the bookmark: %s", resp)
+		t.Errorf("Error in the creation of the bookmark: %v", resp)
 	}
 
 	mn := make(map[string]interface{})
@@ -109,11 +116,10 @@ func TestServer(t *testing.T) {
 		t.Error("Error in Retrieve")
 	}
 
-	books := resp.ResponseMap["result"].(map[string]gomark.Bookmark)
-	book := books["http://google.com"]
+	book := singleResult(t, resp)
 
 	if len(book.GetTags()) != 4 {
-		t.Errorf("Error in update: expected 4 tags got %s", len(book.GetTags()))
+		t.Errorf("Error in update: expected 4 tags got %v", len(book.GetTags()))
 	}
 
 	mn["del_tags"] = []string{"yaourt", "pomme"}
@@ -133,11 +139,10 @@ func TestServer(t *testing.T) {
 		t.Error("Error in Retrieve")
 	}
 
-	books = resp.ResponseMap["result"].(map[string]gomark.Bookmark)
-	book = books["http://google.com"]
+	book = singleResult(t, resp)
 
 	if len(book.GetTags()) != 2 {
-		t.Errorf("Error in update: expected 2 tags got %s", len(book.GetTags()))
+		t.Errorf("Error in update: expected 2 tags got %v", len(book.GetTags()))
 	}
 
 	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mn})
@@ -152,10 +157,10 @@ func TestServer(t *testing.T) {
 	resp = c1.ReadResp()
 
 	if resp.Action != "RETRIEVE_FAIL" {
-		t.Errorf("Error in Retrieve not existent: %s", resp)
+		t.Errorf("Error in Retrieve not existent: %v", resp)
 	}
 
-	data = gomark.BookmarkJSON{"http://google.fr", []string{"tata", "yoyo"}}
+	data = gomark.BookmarkJSON{Url: "http://google.fr", Tags: []string{"tata", "yoyo"}}
 	mm["data"] = data
 
 	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "create", RequestMap: mm})
@@ -171,13 +176,470 @@ func TestServer(t *testing.T) {
 		t.Error("Error in Retrieve multiple bookmarks")
 	}
 
-	mn["url"] = "http://google.com"
+	// Notes and metadata
+	mu := make(map[string]interface{})
+	mu["url"] = "http://google.com"
+	mu["data"] = gomark.BookmarkJSON{Notes: "Best *search* engine", Meta: map[string]string{"lang": "en"}}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "update", RequestMap: mu})
+	resp = c1.ReadResp()
+
+	if noted := singleResult(t, resp); noted.Notes != "Best *search* engine" || noted.Meta["lang"] != "en" {
+		t.Errorf("Error in Update (notes): got %v", noted)
+	}
+
+	mm = make(map[string]interface{})
+	mm["text"] = "*SEARCH* engine"
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if found := singleResult(t, resp); found.ID != book.ID {
+		t.Errorf("Error in Retrieve (text): got %v", found)
+	}
+
+	// Another spelling of the same URL
+	data = gomark.BookmarkJSON{Url: "HTTP://Google.com:80/?utm_source=test", Tags: []string{"pomme"}}
+	mm = make(map[string]interface{})
+	mm["data"] = data
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "create", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "CREATED" || resp.ResponseMap["merged"] != true {
+		t.Errorf("Error in Create (duplicate): %v", resp)
+	}
+
+	if merged := singleResult(t, resp); merged.ID != book.ID || !merged.HasTags("tata", "yoyo", "pomme") {
+		t.Errorf("Error in Create (duplicate): got %v", merged)
+	}
+
+	// Change the URL of a bookmark addressed by its ID
+	mi := make(map[string]interface{})
+	mi["id"] = book.ID
+	mi["data"] = gomark.BookmarkJSON{Url: "http://google.de"}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "update", RequestMap: mi})
+	resp = c1.ReadResp()
+
+	if resp.Action != "UPDATED" {
+		t.Errorf("Error in Update (url): %v", resp)
+	}
+
+	moved := singleResult(t, resp)
+	if moved.GetURL() != "https://google.de" || moved.ID != book.ID || len(moved.GetTags()) != 3 {
+		t.Errorf("Error in Update (url): got %v", moved)
+	}
+
+	mn["url"] = "http://google.de"
 
 	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "delete", RequestMap: mn})
 	resp = c1.ReadResp()
 
 	if resp.Action != "DELETED" {
-		t.Errorf("Error in Delete: %s", resp)
+		t.Errorf("Error in Delete: %v", resp)
+	}
+
+}
+
+// singleResult returns the bookmark of a response, Retrieve returns a list
+// and the other actions a map
+func singleResult(t *testing.T, resp pure.PureMsg) (book gomark.Bookmark) {
+
+	switch books := resp.ResponseMap["result"].(type) {
+
+	case []gomark.Bookmark:
+		if len(books) != 1 {
+			t.Errorf("Expected a single bookmark got %v", len(books))
+		}
+
+		for _, b := range books {
+			book = b
+		}
+
+	case map[string]gomark.Bookmark:
+		if len(books) != 1 {
+			t.Errorf("Expected a single bookmark got %v", len(books))
+		}
+
+		for id, b := range books {
+			if id != b.ID {
+				t.Errorf("Bookmark %s indexed by %s", b.ID, id)
+			}
+			book = b
+		}
+
+	default:
+		t.Fatalf("Unexpected result %v", resp)
+	}
+
+	return
+}
+
+func TestURLChangeServer(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><head><title>Page %s</title></head></html>", r.URL.Path)
+	}))
+	defer ts.Close()
+
+	db := gomark.NewDatabase()
+
+	var server gomark.Server
+	server.Fetcher = testFetcher()
+	gomark.Serve(db, &server, nil)
+
+	updates, cancel := server.Events.Subscribe()
+	defer cancel()
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	enriched := func(expected string) {
+		select {
+		case <-updates:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No event after the enrichment")
+		}
+
+		b, _ := db.GetByURL(ts.URL + expected)
+		if b == nil || b.Title != "Page "+expected {
+			t.Errorf("Error in the enrichment: got %v expected the title of %s", b, expected)
+		}
+	}
+
+	mm := make(map[string]interface{})
+	mm["data"] = gomark.BookmarkJSON{Url: ts.URL + "/a"}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "create", RequestMap: mm})
+	c1.ReadResp()
+	enriched("/a")
+
+	// The page of the new URL is fetched
+	mm["url"] = ts.URL + "/a"
+	mm["data"] = gomark.BookmarkJSON{Url: ts.URL + "/b"}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "update", RequestMap: mm})
+	if resp := c1.ReadResp(); resp.Action != "UPDATED" {
+		t.Fatalf("Error in Update (URL): %v", resp)
+	}
+	enriched("/b")
+}
+
+func TestStateServer(t *testing.T) {
+
+	db := gomark.NewDatabase()
+
+	var server gomark.Server
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	var ids []string
+
+	for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"} {
+		b := gomark.NewBookmark()
+		b.SetURL(u)
+		db.Put(b)
+		ids = append(ids, b.ID)
+	}
+
+	mm := make(map[string]interface{})
+	mm["ids"] = ids[:2]
+	mm["state"] = "read"
+
+	c1.SendReq(pure.PureMsg{DataType: "state", Action: "update", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	if resp.Action != "UPDATED" {
+		t.Errorf("Error in bulk state update: %v", resp)
+	}
+
+	mm = make(map[string]interface{})
+	mm["state"] = "unread"
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if inbox := singleResult(t, resp); inbox.ID != ids[2] {
+		t.Errorf("Error in Retrieve (state): got %v", inbox)
+	}
+
+	c1.SendReq(pure.PureMsg{DataType: "state", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	counts := resp.ResponseMap["result"].(map[gomark.BookmarkState]int)
+	if counts[gomark.StateRead] != 2 || counts[gomark.StateUnread] != 1 {
+		t.Errorf("Error in state counts: %v", counts)
+	}
+
+	mm["ids"] = ids
+	mm["state"] = "done"
+
+	c1.SendReq(pure.PureMsg{DataType: "state", Action: "update", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "UPDATE_FAIL" {
+		t.Errorf("Unknown state accepted: %v", resp)
+	}
+
+}
+
+func TestCheckServer(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	db := gomark.NewDatabase()
+
+	var server gomark.Server
+	server.Fetcher = testFetcher()
+	server.Checker = gomark.NewChecker(db, testFetcher(), 0)
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	var ids []string
+
+	for _, path := range []string{"/ok", "/dead"} {
+		b, _ := gomark.NewBookmarkRawUrl(ts.URL + path)
+		db.Put(b)
+		ids = append(ids, b.ID)
+	}
+
+	mm := make(map[string]interface{})
+	mm["ids"] = ids
+
+	c1.SendReq(pure.PureMsg{DataType: "check", Action: "update", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	if resp.Action != "UPDATED" {
+		t.Errorf("Error in check: %v", resp)
+	}
+
+	mm = make(map[string]interface{})
+	mm["health"] = "dead"
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if dead := singleResult(t, resp); dead.ID != ids[1] || dead.Health.Status != 404 {
+		t.Errorf("Error in Retrieve (health): got %v", dead)
+	}
+
+	c1.SendReq(pure.PureMsg{DataType: "check", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	counts := resp.ResponseMap["result"].(map[string]int)
+	if counts["dead"] != 1 || counts["ok"] != 1 || counts["redirected"] != 0 {
+		t.Errorf("Error in health counts: %v", counts)
+	}
+
+	mm["health"] = "sick"
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "RETRIEVE_FAIL" {
+		t.Errorf("Unknown health accepted: %v", resp)
+	}
+}
+
+func TestQueryServer(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := gomark.NewSQLiteStore(filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The SQLite store uses its indexes for the tags, sites and dates
+	for _, db := range []gomark.Store{gomark.NewDatabase(), s} {
+		testQueryServer(t, db)
+	}
+}
+
+func testQueryServer(t *testing.T, db gomark.Store) {
+
+	var server gomark.Server
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	for _, rawUrl := range []string{"https://github.com/golang/go", "https://example.com/go", "https://www.example.com/old"} {
+		b, _ := gomark.NewBookmarkRawUrl(rawUrl)
+		b.AddTags("go")
+		if strings.HasSuffix(rawUrl, "old") {
+			b.Date = time.Date(2010, 1, 1, 0, 0, 0, 0, time.Local)
+		}
+		db.Put(b)
+	}
+
+	mm := make(map[string]interface{})
+
+	for query, expected := range map[string]string{
+		"tag:go -site:example.com":          "https://github.com/golang/go",
+		"site:example.com after:2011-01-01": "https://example.com/go",
+		"before:2011-01-01 tag:go":          "https://www.example.com/old",
+	} {
+		mm["query"] = query
+
+		c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+		resp := c1.ReadResp()
+
+		if b := singleResult(t, resp); b.GetURL() != expected {
+			t.Errorf("Error in Retrieve (query %s): got %v expected %v", query, b.GetURL(), expected)
+		}
 	}
 
+	mm["query"] = "tag:go AND (site:github.com"
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	if resp.Action != "RETRIEVE_FAIL" {
+		t.Errorf("Invalid query accepted: %v", resp)
+	}
+
+	if err, ok := resp.ResponseMap["error"].(*gomark.QueryError); !ok || err.Position != 27 {
+		t.Errorf("Error in the query error: got %v", resp.ResponseMap["error"])
+	}
+}
+
+func TestSearchServer(t *testing.T) {
+
+	db := gomark.NewDatabase()
+
+	b, _ := gomark.NewBookmarkRawUrl("https://example.com/")
+	b.Title = "Searching bookmarks"
+	db.Put(b)
+
+	var server gomark.Server
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	mm := make(map[string]interface{})
+	mm["text"] = "search"
+
+	c1.SendReq(pure.PureMsg{DataType: "search", Action: "retrieve", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	hits, _ := resp.ResponseMap["result"].([]gomark.SearchHit)
+	if len(hits) != 1 || hits[0].Snippet != "<mark>Searching</mark> bookmarks" {
+		t.Errorf("Error in search: got %v", resp)
+	}
+
+	mm["text"] = " "
+
+	c1.SendReq(pure.PureMsg{DataType: "search", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "RETRIEVE_FAIL" {
+		t.Errorf("Empty search accepted: %v", resp)
+	}
+}
+
+func TestPaginationServer(t *testing.T) {
+
+	db := gomark.NewDatabase()
+
+	var server gomark.Server
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	for _, title := range []string{"b", "c", "a"} {
+		b, _ := gomark.NewBookmarkRawUrl("https://example.com/" + title)
+		b.Title = title
+		db.Put(b)
+	}
+
+	mm := make(map[string]interface{})
+	mm["sort"] = "title"
+	mm["order"] = "desc"
+	mm["limit"] = 2
+	mm["fields"] = []string{"title"}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	books, _ := resp.ResponseMap["result"].([]map[string]json.RawMessage)
+	if len(books) != 2 || string(books[0]["Title"]) != `"c"` || string(books[1]["Title"]) != `"b"` {
+		t.Fatalf("Error in the first page: got %v", resp)
+	}
+
+	if len(books[0]) != 2 || books[0]["ID"] == nil {
+		t.Errorf("Error in the fields: got %v", books[0])
+	}
+
+	mm["cursor"] = resp.ResponseMap["next_cursor"]
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	books, _ = resp.ResponseMap["result"].([]map[string]json.RawMessage)
+	if len(books) != 1 || string(books[0]["Title"]) != `"a"` || resp.ResponseMap["next_cursor"] != "" {
+		t.Errorf("Error in the last page: got %v", resp)
+	}
+
+	mm["fields"] = []string{"password"}
+
+	c1.SendReq(pure.PureMsg{DataType: "bookmark", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "RETRIEVE_FAIL" {
+		t.Errorf("Unknown field accepted: %v", resp)
+	}
+}
+
+func TestTagServer(t *testing.T) {
+
+	db := gomark.NewDatabase()
+
+	db.Put(newTestBookmark(t, "http://example.com/a", "golang"))
+	db.Put(newTestBookmark(t, "http://example.com/b", "go/testing", "old"))
+
+	var server gomark.Server
+	gomark.Serve(db, &server, nil)
+
+	c1 := pure.GoConn{Response: make(chan pure.PureMsg, 1), Muxer: server.Muxer}
+
+	mm := make(map[string]interface{})
+	mm["tags"] = []string{"golang"}
+	mm["new_tag"] = "go"
+
+	c1.SendReq(pure.PureMsg{DataType: "tag", Action: "update", RequestMap: mm})
+	resp := c1.ReadResp()
+
+	if resp.Action != "UPDATED" || resp.ResponseMap["result"] != 1 {
+		t.Errorf("Error while merging tags: %v", resp)
+	}
+
+	mm = make(map[string]interface{})
+	mm["tag"] = "old"
+
+	c1.SendReq(pure.PureMsg{DataType: "tag", Action: "delete", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	if resp.Action != "DELETED" || resp.ResponseMap["result"] != 1 {
+		t.Errorf("Error while deleting a tag: %v", resp)
+	}
+
+	c1.SendReq(pure.PureMsg{DataType: "tag", Action: "retrieve", RequestMap: mm})
+	resp = c1.ReadResp()
+
+	tree := resp.ResponseMap["result"].([]*gomark.TagNode)
+	if len(tree) != 1 || tree[0].Tag != "go" || tree[0].Count != 1 || tree[0].Total != 2 || len(tree[0].Children) != 1 {
+		t.Errorf("Error in tag tree: %v", tree)
+	}
 }
@@ -0,0 +1,528 @@
+package gomark
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// Pure Go driver, no cgo needed
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps the bookmarks in an SQLite database. The whole bookmark
+// is stored as JSON next to the columns used by the indexes so new fields do
+// not need a schema change.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// Each entry upgrades the schema by one version, the current version is
+// kept in the user_version pragma
+var sqliteMigrations = []func(tx *sql.Tx) error{
+	execMigration(`CREATE TABLE bookmarks (
+		url   TEXT PRIMARY KEY,
+		host  TEXT NOT NULL,
+		title TEXT NOT NULL,
+		date  INTEGER NOT NULL,
+		data  TEXT NOT NULL
+	);
+	CREATE TABLE tags (
+		url TEXT NOT NULL REFERENCES bookmarks(url) ON DELETE CASCADE,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (url, tag)
+	);
+	CREATE INDEX tags_tag ON tags(tag);
+	CREATE INDEX bookmarks_host ON bookmarks(host);
+	CREATE INDEX bookmarks_date ON bookmarks(date);`),
+	migrateSQLiteIDs,
+}
+
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// Bookmarks were indexed by URL before having an ID
+func migrateSQLiteIDs(tx *sql.Tx) error {
+
+	_, err := tx.Exec(`CREATE TABLE bookmarks_id (
+		id    TEXT PRIMARY KEY,
+		url   TEXT NOT NULL UNIQUE,
+		host  TEXT NOT NULL,
+		title TEXT NOT NULL,
+		date  INTEGER NOT NULL,
+		data  TEXT NOT NULL
+	);
+	CREATE TABLE tags_id (
+		id  TEXT NOT NULL REFERENCES bookmarks_id(id) ON DELETE CASCADE,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (id, tag)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT data FROM bookmarks")
+	if err != nil {
+		return err
+	}
+
+	var books []*Bookmark
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+
+		// The stored data has no ID, decoding it generates one
+		b, err := decodeBookmark(data)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		books = append(books, b)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO bookmarks_id (id, url, host, title, date, data) VALUES (?, ?, ?, ?, ?, ?)",
+			b.ID, b.GetURL(), b.info.Url.Hostname(), b.Title, b.Date.Unix(), string(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO tags_id (id, tag)
+		SELECT b.id, t.tag FROM tags t JOIN bookmarks_id b ON b.url = t.url;
+	DROP TABLE tags;
+	DROP TABLE bookmarks;
+	ALTER TABLE bookmarks_id RENAME TO bookmarks;
+	ALTER TABLE tags_id RENAME TO tags;
+	CREATE INDEX tags_tag ON tags(tag);
+	CREATE INDEX bookmarks_host ON bookmarks(host);
+	CREATE INDEX bookmarks_date ON bookmarks(date);`)
+
+	return err
+}
+
+func NewSQLiteStore(filename string) (s *SQLiteStore, err error) {
+
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return
+	}
+
+	// A single connection serializes the writers, SQLite would only answer
+	// SQLITE_BUSY to the concurrent ones anyway
+	db.SetMaxOpenConns(1)
+
+	s = &SQLiteStore{db}
+
+	_, err = db.Exec("PRAGMA foreign_keys = ON; PRAGMA journal_mode = WAL;")
+	if err == nil {
+		err = s.migrate()
+	}
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error while opening SQLite store %s: %v", filename, err)
+	}
+
+	return
+}
+
+func (s *SQLiteStore) migrate() error {
+
+	var version int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("Schema version %v is newer than the supported version %v", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = sqliteMigrations[version](tx)
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error in migration to version %v: %v", version+1, err)
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Get(id string) (*Bookmark, error) {
+	return s.get("SELECT data FROM bookmarks WHERE id = ?", id)
+}
+
+func (s *SQLiteStore) GetByURL(url string) (*Bookmark, error) {
+	return s.get("SELECT data FROM bookmarks WHERE url = ?", url)
+}
+
+func (s *SQLiteStore) get(query string, key string) (*Bookmark, error) {
+
+	var data string
+	err := s.db.QueryRow(query, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Bookmark not found: %s", key)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBookmark(data)
+}
+
+func (s *SQLiteStore) Put(b *Bookmark) error {
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = putBookmark(tx, b, data)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) PutMany(books []*Bookmark) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		data, err := json.Marshal(b)
+		if err == nil {
+			err = putBookmark(tx, b, data)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Update(id string, fn func(b *Bookmark) error) (*Bookmark, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := updateBookmark(tx, id, fn)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return b, tx.Commit()
+}
+
+func (s *SQLiteStore) UpdateMany(filter func(b *Bookmark) bool, fn func(b *Bookmark) error) (int, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := updateBookmarks(tx, filter, fn)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+func updateBookmarks(tx *sql.Tx, filter func(b *Bookmark) bool, fn func(b *Bookmark) error) (int, error) {
+
+	rows, err := tx.Query("SELECT data FROM bookmarks")
+	if err != nil {
+		return 0, err
+	}
+
+	// The rows are read before writing through the single connection
+	books, err := scanBookmarks(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	var matching []*Bookmark
+
+	for i := range books {
+		b := &books[i]
+		if !filter(b) {
+			continue
+		}
+
+		id := b.ID
+		if err = fn(b); err != nil {
+			return 0, err
+		}
+
+		if b.ID != id {
+			return 0, fmt.Errorf("The ID of bookmark %s cannot change", id)
+		}
+
+		matching = append(matching, b)
+	}
+
+	for _, b := range matching {
+		data, err := json.Marshal(b)
+		if err == nil {
+			err = putBookmark(tx, b, data)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(matching), nil
+}
+
+func updateBookmark(tx *sql.Tx, id string, fn func(b *Bookmark) error) (*Bookmark, error) {
+
+	var data string
+	err := tx.QueryRow("SELECT data FROM bookmarks WHERE id = ?", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Bookmark not found: %s", id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := decodeBookmark(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fn(b); err != nil {
+		return nil, err
+	}
+
+	if b.ID != id {
+		return nil, fmt.Errorf("The ID of bookmark %s cannot change", id)
+	}
+
+	encoded, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, putBookmark(tx, b, encoded)
+}
+
+func putBookmark(tx *sql.Tx, b *Bookmark, data []byte) error {
+
+	url := b.GetURL()
+
+	var id string
+	err := tx.QueryRow("SELECT id FROM bookmarks WHERE url = ?", url).Scan(&id)
+	if err == nil && id != b.ID {
+		return fmt.Errorf("Bookmark %s already uses %s", id, url)
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO bookmarks (id, url, host, title, date, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET url = excluded.url, host = excluded.host,
+		title = excluded.title, date = excluded.date, data = excluded.data`,
+		b.ID, url, b.info.Url.Hostname(), b.Title, b.Date.Unix(), string(data))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM tags WHERE id = ?", b.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range b.GetTags() {
+		_, err = tx.Exec("INSERT INTO tags (id, tag) VALUES (?, ?)", b.ID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Delete(id string) error {
+
+	res, err := s.db.Exec("DELETE FROM bookmarks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("Bookmark not found: %s", id)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) List() (map[string]Bookmark, error) {
+
+	books, err := s.query("SELECT data FROM bookmarks")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]Bookmark, len(books))
+	for _, b := range books {
+		result[b.ID] = b
+	}
+
+	return result, nil
+}
+
+func (s *SQLiteStore) Iterate(fn func(b *Bookmark) error) error {
+
+	// The rows are read before calling fn as it may write to the store
+	// through the single connection
+	books, err := s.query("SELECT data FROM bookmarks")
+	if err != nil {
+		return err
+	}
+
+	for i := range books {
+		if err = fn(&books[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// FindByTag returns the bookmarks tagged with tag or one of its
+// descendants, newest first
+func (s *SQLiteStore) FindByTag(tag string) ([]Bookmark, error) {
+
+	tag = normalizeTag(tag)
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(tag)
+
+	return s.query(`SELECT b.data FROM bookmarks b WHERE EXISTS
+		(SELECT 1 FROM tags t WHERE t.id = b.id AND (t.tag = ? OR t.tag LIKE ? ESCAPE '\'))
+		ORDER BY b.date DESC`, tag, escaped+"/%")
+}
+
+// FindByHost returns the bookmarks pointing to host or one of its
+// subdomains, newest first
+func (s *SQLiteStore) FindByHost(host string) ([]Bookmark, error) {
+
+	host = strings.ToLower(host)
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(host)
+
+	return s.query(`SELECT data FROM bookmarks WHERE host = ? OR host LIKE ? ESCAPE '\'
+		ORDER BY date DESC`, host, "%."+escaped)
+}
+
+// FindByDate returns the bookmarks created in [from, to), newest first
+func (s *SQLiteStore) FindByDate(from time.Time, to time.Time) ([]Bookmark, error) {
+	return s.query("SELECT data FROM bookmarks WHERE date >= ? AND date < ? ORDER BY date DESC",
+		from.Unix(), to.Unix())
+}
+
+func (s *SQLiteStore) query(query string, args ...interface{}) (books []Bookmark, err error) {
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+
+	return scanBookmarks(rows)
+}
+
+// scanBookmarks reads and closes rows of bookmark data
+func scanBookmarks(rows *sql.Rows) (books []Bookmark, err error) {
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return
+		}
+
+		b, err := decodeBookmark(data)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, *b)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func decodeBookmark(data string) (*Bookmark, error) {
+
+	b := NewBookmark()
+	err := json.Unmarshal([]byte(data), b)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupted bookmark in store: %v", err)
+	}
+
+	return b, nil
+}
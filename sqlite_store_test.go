@@ -0,0 +1,197 @@
+package gomark_test
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.sqlite")
+
+	s, err := gomark.NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatalf("Error while creating SQLite store: %s", err)
+	}
+
+	testStore(t, s)
+
+	if err = s.Close(); err != nil {
+		t.Errorf("Error while closing: %s", err)
+	}
+
+	// Reopening must not run the migrations again
+	s, err = gomark.NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatalf("Error while reopening SQLite store: %s", err)
+	}
+	defer s.Close()
+
+	all, _ := s.List()
+	if len(all) != 2 {
+		t.Errorf("Changes not persisted: expected 2 bookmarks got %v", len(all))
+	}
+
+}
+
+func TestSQLiteQueries(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := gomark.NewSQLiteStore(filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		t.Fatalf("Error while creating SQLite store: %s", err)
+	}
+	defer s.Close()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		b := newTestBookmark(t, fmt.Sprintf("http://site%d.com/page", i%2), "all")
+		b.Date = start.AddDate(0, 0, i)
+		if i%2 == 0 {
+			b.AddTags("Even")
+		}
+		s.Put(b)
+	}
+
+	b := newTestBookmark(t, "http://site0.com/other", "all")
+	b.Date = start.AddDate(0, 0, 10)
+	s.Put(b)
+
+	books, err := s.FindByTag("even")
+	if err != nil || len(books) != 1 {
+		t.Errorf("Error in FindByTag: expected 1 bookmark got %v (%v)", len(books), err)
+	}
+
+	// Descendants match, each bookmark once
+	for i, tags := range [][]string{{"lang/go", "lang/go/testing"}, {"Lang/Go/Testing"}, {"lang/gopher"}, {"lang_go"}} {
+		b := newTestBookmark(t, fmt.Sprintf("http://site2.com/%d", i), tags...)
+		b.Date = start.AddDate(0, 0, 20)
+		s.Put(b)
+	}
+
+	books, err = s.FindByTag("lang/go")
+	if err != nil || len(books) != 2 {
+		t.Errorf("Error in FindByTag (descendants): expected 2 bookmarks got %v (%v)", len(books), err)
+	}
+
+	books, err = s.FindByHost("site0.com")
+	if err != nil || len(books) != 2 {
+		t.Errorf("Error in FindByHost: expected 2 bookmarks got %v (%v)", len(books), err)
+	}
+
+	if len(books) == 2 && books[0].GetURL() != "http://site0.com/other" {
+		t.Errorf("Error in FindByHost: results not sorted by date, got %s first", books[0].GetURL())
+	}
+
+	// Subdomains match, not the hosts ending with the same name
+	for _, rawUrl := range []string{"http://www.site1.com/sub", "http://othersite1.com/"} {
+		b := newTestBookmark(t, rawUrl)
+		b.Date = start.AddDate(0, 0, 30)
+		s.Put(b)
+	}
+
+	books, err = s.FindByHost("site1.com")
+	if err != nil || len(books) != 2 {
+		t.Errorf("Error in FindByHost (subdomains): expected 2 bookmarks got %v (%v)", len(books), err)
+	}
+
+	books, err = s.FindByDate(start, start.AddDate(0, 0, 5))
+	if err != nil || len(books) != 2 {
+		t.Errorf("Error in FindByDate: expected 2 bookmarks got %v (%v)", len(books), err)
+	}
+
+}
+
+func TestCopyBookmarks(t *testing.T) {
+
+	src := gomark.NewDatabase()
+	src.AddBookmark(newTestBookmark(t, "http://example.com/a", "tata"))
+	src.AddBookmark(newTestBookmark(t, "http://example.com/b"))
+
+	dst, err := gomark.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("Error while creating SQLite store: %s", err)
+	}
+	defer dst.Close()
+
+	n, err := gomark.CopyBookmarks(dst, src)
+	if err != nil || n != 2 {
+		t.Errorf("Error in CopyBookmarks: expected 2 bookmarks got %v (%v)", n, err)
+	}
+
+	b, err := dst.GetByURL("http://example.com/a")
+	if err != nil || !b.HasTags("tata") {
+		t.Errorf("Error in CopyBookmarks: bookmark not copied with its tags (%v)", err)
+	}
+
+}
+
+func TestSQLiteMigrateIDs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.sqlite")
+
+	// A store created before bookmarks had an ID
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE bookmarks (url TEXT PRIMARY KEY, host TEXT NOT NULL,
+		title TEXT NOT NULL, date INTEGER NOT NULL, data TEXT NOT NULL);
+	CREATE TABLE tags (url TEXT NOT NULL REFERENCES bookmarks(url) ON DELETE CASCADE,
+		tag TEXT NOT NULL, PRIMARY KEY (url, tag));
+	INSERT INTO bookmarks VALUES ('http://example.com', 'example.com', 'Example', 0,
+		'{"Url": "http://example.com", "Title": "Example", "Tags": ["tata"]}');
+	INSERT INTO tags VALUES ('http://example.com', 'tata');
+	PRAGMA user_version = 1;`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := gomark.NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatalf("Error while migrating: %s", err)
+	}
+	defer s.Close()
+
+	b, err := s.GetByURL("http://example.com")
+	if err != nil || len(b.ID) == 0 {
+		t.Fatalf("Error in migration: bookmark without ID (%v)", err)
+	}
+
+	b1, err := s.Get(b.ID)
+	if err != nil || b1.Title != "Example" {
+		t.Errorf("Error in migration: ID not stored (%v)", err)
+	}
+
+	books, err := s.FindByTag("tata")
+	if err != nil || len(books) != 1 {
+		t.Errorf("Error in migration: tags lost (%v)", err)
+	}
+
+}
@@ -0,0 +1,65 @@
+package gomark
+
+import (
+	"fmt"
+	"time"
+)
+
+// BookmarkState is the position of a bookmark in the reading queue
+type BookmarkState string
+
+const (
+	StateUnread   BookmarkState = "unread"
+	StateRead     BookmarkState = "read"
+	StateArchived BookmarkState = "archived"
+)
+
+// StateChange records when a bookmark entered a state
+type StateChange struct {
+	State BookmarkState
+	Date  time.Time
+}
+
+func ParseState(s string) (BookmarkState, error) {
+
+	switch state := BookmarkState(s); state {
+	case StateUnread, StateRead, StateArchived:
+		return state, nil
+	}
+
+	return "", fmt.Errorf("Unknown state %s", s)
+}
+
+// GetState returns the current state, bookmarks saved before states
+// existed are unread
+func (b *Bookmark) GetState() BookmarkState {
+
+	if len(b.State) == 0 {
+		return StateUnread
+	}
+
+	return b.State
+}
+
+// SetState moves the bookmark to state and records the transition
+func (b *Bookmark) SetState(state BookmarkState) {
+
+	if b.GetState() == state && len(b.StateHistory) > 0 {
+		return
+	}
+
+	b.State = state
+	b.StateHistory = append(b.StateHistory, StateChange{state, time.Now()})
+}
+
+// StateDate returns when the bookmark last entered state
+func (b *Bookmark) StateDate(state BookmarkState) (date time.Time, ok bool) {
+
+	for i := len(b.StateHistory) - 1; i >= 0; i-- {
+		if b.StateHistory[i].State == state {
+			return b.StateHistory[i].Date, true
+		}
+	}
+
+	return
+}
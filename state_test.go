@@ -0,0 +1,40 @@
+package gomark_test
+
+import (
+	"github.com/th3osmith/gomark"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+
+	b := gomark.NewBookmark()
+
+	if b.GetState() != gomark.StateUnread {
+		t.Errorf("New bookmark not unread: %s", b.GetState())
+	}
+
+	b.SetState(gomark.StateRead)
+	b.SetState(gomark.StateRead)
+	b.SetState(gomark.StateArchived)
+
+	if b.GetState() != gomark.StateArchived || len(b.StateHistory) != 3 {
+		t.Errorf("Error in SetState: got %s after %v transitions", b.GetState(), len(b.StateHistory))
+	}
+
+	read, ok := b.StateDate(gomark.StateRead)
+	archived, _ := b.StateDate(gomark.StateArchived)
+	if !ok || archived.Before(read) {
+		t.Errorf("Error in StateDate: read %v archived %v", read, archived)
+	}
+
+	// Bookmarks saved before states existed
+	var old gomark.Bookmark
+	if old.GetState() != gomark.StateUnread {
+		t.Errorf("Bookmark without state not unread: %s", old.GetState())
+	}
+
+	if _, err := gomark.ParseState("done"); err == nil {
+		t.Error("Unknown state accepted")
+	}
+
+}
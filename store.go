@@ -0,0 +1,292 @@
+package gomark
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Store is the storage backend of the bookmarks. Implementations must be
+// safe for concurrent use and persist every change before returning.
+type Store interface {
+	// Get returns a copy of the bookmark with the given ID
+	Get(id string) (*Bookmark, error)
+	GetByURL(url string) (*Bookmark, error)
+	// Put creates or replaces the bookmark with the same ID, two bookmarks
+	// cannot share an URL
+	Put(b *Bookmark) error
+	// PutMany puts every bookmark at once, if one cannot be stored none is
+	PutMany(books []*Bookmark) error
+	// Update applies fn to a copy of the bookmark with the given ID and
+	// stores it, no other change can happen in between. Nothing is stored
+	// if fn returns an error. fn must not call the store.
+	Update(id string, fn func(b *Bookmark) error) (*Bookmark, error)
+	// UpdateMany applies fn to a copy of every bookmark matching filter and
+	// stores them at once, as Update does for one, and returns how many
+	// were changed. Nothing is stored if fn returns an error.
+	UpdateMany(filter func(b *Bookmark) bool, fn func(b *Bookmark) error) (int, error)
+	Delete(id string) error
+	// List returns a snapshot of every bookmark indexed by ID
+	List() (map[string]Bookmark, error)
+	// Iterate calls fn on every bookmark until fn returns an error
+	Iterate(fn func(b *Bookmark) error) error
+	Close() error
+}
+
+// Finder is implemented by the stores able to select bookmarks with their
+// indexes instead of going through all of them
+type Finder interface {
+	// FindByTag returns the bookmarks tagged with tag or one of its
+	// descendants
+	FindByTag(tag string) ([]Bookmark, error)
+	// FindByHost returns the bookmarks pointing to host or one of its
+	// subdomains
+	FindByHost(host string) ([]Bookmark, error)
+	// FindByDate returns the bookmarks created in [from, to)
+	FindByDate(from time.Time, to time.Time) ([]Bookmark, error)
+}
+
+// errURLChanged cancels the update of a background task when the URL of the
+// bookmark changed during its fetch
+var errURLChanged = errors.New("URL changed during the fetch")
+
+// The JSON file backend, a database without Filename is only kept in memory
+
+func (d *Database) Get(id string) (*Bookmark, error) {
+	return d.GetBookmark(id)
+}
+
+func (d *Database) GetByURL(url string) (*Bookmark, error) {
+	return d.GetBookmarkByURL(url)
+}
+
+func (d *Database) Put(b *Bookmark) error {
+
+	d.mutex.Lock()
+
+	if id, ok := d.urls[b.GetURL()]; ok && id != b.ID {
+		d.mutex.Unlock()
+		return fmt.Errorf("Bookmark %s already uses %s", id, b.GetURL())
+	}
+
+	// Applied once recorded, a failed write changes nothing
+	book := b.clone()
+	err := d.writeJournal(journalEntry{Op: journalPut, Bookmark: &book})
+	if err == nil {
+		d.set(book)
+	}
+	d.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return d.compactIfNeeded()
+}
+
+// PutMany writes a single snapshot instead of a journal entry per bookmark
+func (d *Database) PutMany(books []*Bookmark) error {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.putMany(books)
+}
+
+// putMany is PutMany for the callers holding the lock
+func (d *Database) putMany(books []*Bookmark) error {
+
+	// URLs as they will be once the bookmarks are stored
+	urls := make(map[string]string, len(books))
+	for _, b := range books {
+		if old, ok := d.Bookmarks[b.ID]; ok {
+			urls[old.GetURL()] = ""
+		}
+	}
+
+	for _, b := range books {
+		url := b.GetURL()
+
+		id, ok := urls[url]
+		if !ok {
+			id, ok = d.urls[url]
+		}
+
+		if ok && len(id) > 0 && id != b.ID {
+			return fmt.Errorf("Bookmark %s already uses %s", id, url)
+		}
+
+		urls[url] = b.ID
+	}
+
+	previous := make(map[string]*Bookmark, len(books))
+	for _, b := range books {
+		if old, ok := d.Bookmarks[b.ID]; ok {
+			previous[b.ID] = &old
+		} else {
+			previous[b.ID] = nil
+		}
+
+		d.set(b.clone())
+	}
+
+	if len(d.Filename) == 0 {
+		return nil
+	}
+
+	err := d.dump()
+	if err != nil {
+		// Nothing was written, forget the changes
+		for id, old := range previous {
+			if old == nil {
+				d.remove(id)
+			} else {
+				d.set(*old)
+			}
+		}
+	}
+
+	return err
+}
+
+func (d *Database) Update(id string, fn func(b *Bookmark) error) (*Bookmark, error) {
+
+	d.mutex.Lock()
+
+	old, ok := d.Bookmarks[id]
+	if !ok {
+		d.mutex.Unlock()
+		return nil, fmt.Errorf("Bookmark not found: %s", id)
+	}
+
+	book := old.clone()
+	if err := fn(&book); err != nil {
+		d.mutex.Unlock()
+		return nil, err
+	}
+
+	if book.ID != id {
+		d.mutex.Unlock()
+		return nil, fmt.Errorf("The ID of bookmark %s cannot change", id)
+	}
+
+	if other, ok := d.urls[book.GetURL()]; ok && other != id {
+		d.mutex.Unlock()
+		return nil, fmt.Errorf("Bookmark %s already uses %s", other, book.GetURL())
+	}
+
+	err := d.writeJournal(journalEntry{Op: journalPut, Bookmark: &book})
+	if err == nil {
+		d.set(book.clone())
+	}
+	d.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &book, d.compactIfNeeded()
+}
+
+func (d *Database) UpdateMany(filter func(b *Bookmark) bool, fn func(b *Bookmark) error) (int, error) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var changed []*Bookmark
+
+	for _, b := range d.Bookmarks {
+		if !filter(&b) {
+			continue
+		}
+
+		c := b.clone()
+		if err := fn(&c); err != nil {
+			return 0, err
+		}
+
+		if c.ID != b.ID {
+			return 0, fmt.Errorf("The ID of bookmark %s cannot change", b.ID)
+		}
+
+		changed = append(changed, &c)
+	}
+
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	if err := d.putMany(changed); err != nil {
+		return 0, err
+	}
+
+	return len(changed), nil
+}
+
+func (d *Database) Delete(id string) error {
+
+	d.mutex.Lock()
+
+	if _, ok := d.Bookmarks[id]; !ok {
+		d.mutex.Unlock()
+		return fmt.Errorf("Bookmark not found: %s", id)
+	}
+
+	err := d.writeJournal(journalEntry{Op: journalDelete, ID: id})
+	if err == nil {
+		d.remove(id)
+	}
+	d.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return d.compactIfNeeded()
+}
+
+func (d *Database) List() (map[string]Bookmark, error) {
+	return d.GetBookmarks(), nil
+}
+
+func (d *Database) Iterate(fn func(b *Bookmark) error) error {
+
+	// Work on a snapshot so fn is free to modify the database
+	for _, b := range d.GetBookmarks() {
+		b := b
+		if err := fn(&b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close writes a last snapshot and releases the journal
+func (d *Database) Close() error {
+
+	if len(d.Filename) == 0 {
+		return nil
+	}
+
+	if err := d.Dump(); err != nil {
+		return err
+	}
+
+	return d.closeJournal()
+}
+
+// CopyBookmarks puts every bookmark of src into dst and returns how many
+// were copied
+func CopyBookmarks(dst Store, src Store) (n int, err error) {
+
+	err = src.Iterate(func(b *Bookmark) error {
+		if err := dst.Put(b); err != nil {
+			return fmt.Errorf("Error while copying %s: %v", b.GetURL(), err)
+		}
+		n++
+		return nil
+	})
+
+	return
+}
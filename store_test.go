@@ -0,0 +1,241 @@
+package gomark_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var stopUpdate = errors.New("stop update")
+
+// testStore runs the behaviour every Store implementation must provide
+func testStore(t *testing.T, store gomark.Store) {
+
+	ids := make(map[string]string)
+
+	for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"} {
+		b := newTestBookmark(t, u, "tata")
+		if err := store.Put(b); err != nil {
+			t.Fatalf("Error while putting %s: %s", u, err)
+		}
+		ids[u] = b.ID
+	}
+
+	b, err := store.GetByURL("http://example.com/a")
+	if err != nil || b.ID != ids["http://example.com/a"] {
+		t.Fatalf("Error while getting bookmark by URL: %v", err)
+	}
+
+	// The returned bookmark must be a copy
+	b.AddTags("yoyo")
+
+	b1, _ := store.Get(b.ID)
+	if b1.HasTags("yoyo") {
+		t.Error("Store shares its bookmarks with the caller")
+	}
+
+	if err = store.Put(b); err != nil {
+		t.Fatalf("Error while updating bookmark: %s", err)
+	}
+
+	b1, _ = store.Get(b.ID)
+	if !b1.HasTags("tata", "yoyo") {
+		t.Errorf("Error in update: got tags %v", b1.GetTags())
+	}
+
+	// Changing the URL keeps the ID
+	b.SetURL("http://example.com/d")
+	if err = store.Put(b); err != nil {
+		t.Fatalf("Error while changing the URL: %s", err)
+	}
+
+	if b1, err = store.GetByURL(b.GetURL()); err != nil || b1.ID != b.ID {
+		t.Errorf("Error in URL change: %v", err)
+	}
+
+	if _, err = store.GetByURL("http://example.com/a"); err == nil {
+		t.Error("Old URL still indexed after a change")
+	}
+
+	// Two bookmarks cannot share an URL
+	if err = store.Put(newTestBookmark(t, "http://example.com/c")); err == nil {
+		t.Error("Duplicate URL accepted")
+	}
+
+	if _, err = store.Get("http://example.com/z"); err == nil {
+		t.Error("Bad error reporting in Get")
+	}
+
+	if _, err = store.GetByURL("http://example.com/z"); err == nil {
+		t.Error("Bad error reporting in GetByURL")
+	}
+
+	if err = store.Delete(ids["http://example.com/b"]); err != nil {
+		t.Errorf("Error while deleting: %s", err)
+	}
+
+	if err = store.Delete(ids["http://example.com/b"]); err == nil {
+		t.Error("Bad error reporting in Delete")
+	}
+
+	// PutMany stores every bookmark or none
+	c, _ := store.GetByURL("http://example.com/c")
+	c.AddTags("many")
+
+	err = store.PutMany([]*gomark.Bookmark{c, newTestBookmark(t, "http://example.com/e"), newTestBookmark(t, "http://example.com/c")})
+	if err == nil {
+		t.Error("Duplicate URL accepted by PutMany")
+	}
+
+	if _, err = store.GetByURL("http://example.com/e"); err == nil {
+		t.Error("PutMany stored part of the bookmarks")
+	}
+
+	if c1, _ := store.Get(c.ID); c1.HasTags("many") {
+		t.Error("PutMany updated part of the bookmarks")
+	}
+
+	if err = store.PutMany([]*gomark.Bookmark{c, b}); err != nil {
+		t.Errorf("Error in PutMany: %v", err)
+	}
+
+	if c1, _ := store.Get(c.ID); !c1.HasTags("many") {
+		t.Errorf("Error in PutMany: got tags %v", c1.GetTags())
+	}
+
+	// Update changes a bookmark without losing the concurrent updates
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			if _, err := store.Update(c.ID, func(b *gomark.Bookmark) error {
+				b.AddTags(tag)
+				return nil
+			}); err != nil {
+				t.Errorf("Error in Update: %v", err)
+			}
+		}(fmt.Sprintf("update%v", i))
+	}
+	wg.Wait()
+
+	c1, _ := store.Get(c.ID)
+	if tags := c1.GetTags(); len(tags) != 12 || !c1.HasTags("update0", "update9") {
+		t.Errorf("Error in concurrent updates: got tags %v", tags)
+	}
+
+	c1, err = store.Update(c.ID, func(b *gomark.Bookmark) error {
+		b.DeleteTags("many")
+		return nil
+	})
+	if err != nil || c1.HasTags("many") {
+		t.Errorf("Error in Update: got %v, %v", c1, err)
+	}
+
+	_, err = store.Update(c.ID, func(b *gomark.Bookmark) error {
+		b.AddTags("failed")
+		return stopUpdate
+	})
+	if c1, _ = store.Get(c.ID); err != stopUpdate || c1.HasTags("failed") {
+		t.Errorf("Update stored a failed change: got %v", err)
+	}
+
+	_, err = store.Update(c.ID, func(b *gomark.Bookmark) error {
+		return b.SetURL("http://example.com/d")
+	})
+	if err == nil {
+		t.Error("Duplicate URL accepted by Update")
+	}
+
+	if _, err = store.Update("http://example.com/z", func(b *gomark.Bookmark) error { return nil }); err == nil {
+		t.Error("Bad error reporting in Update")
+	}
+
+	// UpdateMany changes every matching bookmark or none
+	tagged := func(b *gomark.Bookmark) bool { return b.HasTags("tata") }
+
+	_, err = store.UpdateMany(tagged, func(b *gomark.Bookmark) error {
+		if b.ID == c.ID {
+			return stopUpdate
+		}
+		b.AddTags("failed")
+		return nil
+	})
+	if b1, _ = store.Get(b.ID); err != stopUpdate || b1.HasTags("failed") {
+		t.Errorf("UpdateMany stored a failed change: got %v", err)
+	}
+
+	n, err := store.UpdateMany(tagged, func(b *gomark.Bookmark) error {
+		b.AddTags("bulk")
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Errorf("Error in UpdateMany: expected 2 bookmarks got %v (%v)", n, err)
+	}
+
+	if c1, _ = store.Get(c.ID); !c1.HasTags("bulk", "update0") {
+		t.Errorf("Error in UpdateMany: got tags %v", c1.GetTags())
+	}
+
+	all, err := store.List()
+	if err != nil || len(all) != 2 {
+		t.Errorf("Error in List: expected 2 bookmarks got %v (%v)", len(all), err)
+	}
+
+	count := 0
+	err = store.Iterate(func(b *gomark.Bookmark) error {
+		count++
+		return nil
+	})
+
+	if err != nil || count != 2 {
+		t.Errorf("Error in Iterate: expected 2 bookmarks got %v (%v)", count, err)
+	}
+
+	stop := errors.New("stop")
+	err = store.Iterate(func(b *gomark.Bookmark) error {
+		return stop
+	})
+
+	if err != stop {
+		t.Errorf("Iterate did not stop on error: got %v", err)
+	}
+
+}
+
+func TestDatabaseStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	d := gomark.NewDatabase()
+	d.Filename = filename
+
+	testStore(t, d)
+
+	if err = d.Close(); err != nil {
+		t.Errorf("Error while closing: %s", err)
+	}
+
+	d1, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Error while reading back the store: %s", err)
+	}
+
+	if len(d1.Bookmarks) != 2 {
+		t.Errorf("Changes not persisted: expected 2 bookmarks got %v", len(d1.Bookmarks))
+	}
+
+	testStore(t, gomark.NewDatabase())
+
+}
@@ -0,0 +1,184 @@
+package gomark
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CountTags returns the number of bookmarks using each tag
+func CountTags(store Store) (map[string]int, error) {
+
+	counts := make(map[string]int)
+
+	err := store.Iterate(func(b *Bookmark) error {
+		for _, tag := range b.GetTags() {
+			counts[tag]++
+		}
+		return nil
+	})
+
+	return counts, err
+}
+
+// RenameTag replaces tag by newTag on every bookmark, its descendants move
+// along, and returns the number of bookmarks changed
+func RenameTag(store Store, tag string, newTag string) (int, error) {
+	return MergeTags(store, []string{tag}, newTag)
+}
+
+// MergeTags replaces the tags by newTag on every bookmark, at once, and
+// returns the number of bookmarks changed. The descendants of the tags move
+// under newTag.
+func MergeTags(store Store, tags []string, newTag string) (int, error) {
+
+	newTag = normalizeTag(newTag)
+	if len(newTag) == 0 {
+		return 0, fmt.Errorf("Empty tag")
+	}
+
+	tags = normalizeTags(tags)
+
+	return updateTags(store, tags, func(b *Bookmark) {
+		var moved []string
+
+		for _, t := range b.GetTags() {
+			for _, tag := range tags {
+				if tagWithin(t, tag) {
+					b.DeleteTags(t)
+					moved = append(moved, newTag+t[len(tag):])
+					break
+				}
+			}
+		}
+
+		// Added last, a moved tag may have the name of another one
+		b.AddTags(moved...)
+	})
+}
+
+// DeleteTag removes the tags and their descendants from every bookmark, at
+// once, and returns the number of bookmarks changed
+func DeleteTag(store Store, tags ...string) (int, error) {
+
+	tags = normalizeTags(tags)
+
+	return updateTags(store, tags, func(b *Bookmark) {
+		for _, t := range b.GetTags() {
+			for _, tag := range tags {
+				if tagWithin(t, tag) {
+					b.DeleteTags(t)
+				}
+			}
+		}
+	})
+}
+
+// normalizeTags drops the empty tags
+func normalizeTags(tags []string) (normalized []string) {
+
+	for _, tag := range tags {
+		if tag = normalizeTag(tag); len(tag) > 0 {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return
+}
+
+// updateTags applies change to the bookmarks having one of the tags, in a
+// single UpdateMany
+func updateTags(store Store, tags []string, change func(b *Bookmark)) (int, error) {
+
+	if len(tags) == 0 {
+		return 0, fmt.Errorf("No tag given")
+	}
+
+	return store.UpdateMany(func(b *Bookmark) bool {
+		for _, tag := range tags {
+			if b.HasTags(tag) {
+				return true
+			}
+		}
+		return false
+	}, func(b *Bookmark) error {
+		change(b)
+		return nil
+	})
+}
+
+// TagNode is a level of the tag hierarchy
+type TagNode struct {
+	// Last level of the tag
+	Name string
+	Tag  string
+	// Bookmarks having exactly this tag
+	Count int
+	// Bookmarks having this tag or one of its descendants
+	Total    int
+	Children []*TagNode `json:",omitempty"`
+}
+
+// TagTree returns the top level tags with their descendants, sorted by name
+func TagTree(store Store) ([]*TagNode, error) {
+
+	nodes := make(map[string]*TagNode)
+
+	node := func(tag string) *TagNode {
+		n, ok := nodes[tag]
+		if !ok {
+			n = &TagNode{Name: tag[strings.LastIndex(tag, "/")+1:], Tag: tag}
+			nodes[tag] = n
+		}
+		return n
+	}
+
+	err := store.Iterate(func(b *Bookmark) error {
+
+		// A bookmark counts once for an ancestor of several of its tags
+		within := make(map[string]bool)
+
+		for _, tag := range b.GetTags() {
+			node(tag).Count++
+
+			for i := range tag {
+				if tag[i] == '/' {
+					within[tag[:i]] = true
+				}
+			}
+			within[tag] = true
+		}
+
+		for tag := range within {
+			node(tag).Total++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*TagNode
+
+	for tag, n := range nodes {
+		if i := strings.LastIndex(tag, "/"); i >= 0 {
+			parent := nodes[tag[:i]]
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+
+	for _, n := range nodes {
+		sortTagNodes(n.Children)
+	}
+	sortTagNodes(roots)
+
+	return roots, nil
+}
+
+func sortTagNodes(nodes []*TagNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+}
@@ -0,0 +1,160 @@
+package gomark_test
+
+import (
+	"fmt"
+	"github.com/th3osmith/gomark"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gomark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	db := gomark.NewDatabase()
+	db.Filename = filename
+
+	db.Put(newTestBookmark(t, "http://example.com/a", "golang", "web"))
+	db.Put(newTestBookmark(t, "http://example.com/b", "Go", "tools"))
+	db.Put(newTestBookmark(t, "http://example.com/c", "go-lang", "golang"))
+	db.Put(newTestBookmark(t, "http://example.com/d", "misc"))
+
+	counts, err := gomark.CountTags(db)
+	if err != nil || counts["golang"] != 2 || counts["go"] != 1 || len(counts) != 6 {
+		t.Errorf("Error in the counts: got %v (%v)", counts, err)
+	}
+
+	n, err := gomark.MergeTags(db, []string{"golang", "go-lang"}, "go")
+	if err != nil || n != 2 {
+		t.Errorf("Error while merging: got %v (%v)", n, err)
+	}
+
+	// A single snapshot is written instead of journal entries
+	if info, err := os.Stat(filename + ".journal"); err == nil && info.Size() > 0 {
+		t.Errorf("Merge written to the journal")
+	}
+
+	saved, err := gomark.NewDatabaseFromFile(filename)
+	if err != nil {
+		t.Fatalf("Error while reading back the database: %v", err)
+	}
+
+	counts, _ = gomark.CountTags(saved)
+	if counts["go"] != 3 || counts["golang"] != 0 || counts["go-lang"] != 0 {
+		t.Errorf("Error in the counts after merging: got %v", counts)
+	}
+
+	if n, err = gomark.RenameTag(db, "Tools", "tooling"); err != nil || n != 1 {
+		t.Errorf("Error while renaming: got %v (%v)", n, err)
+	}
+
+	if b, _ := db.GetByURL("http://example.com/b"); !b.HasTags("go", "tooling") || b.HasTags("tools") {
+		t.Errorf("Error in rename: got tags %v", b.GetTags())
+	}
+
+	if n, err = gomark.DeleteTag(db, "go"); err != nil || n != 3 {
+		t.Errorf("Error while deleting: got %v (%v)", n, err)
+	}
+
+	counts, _ = gomark.CountTags(db)
+	if counts["go"] != 0 || counts["misc"] != 1 || len(counts) != 3 {
+		t.Errorf("Error in the counts after deleting: got %v", counts)
+	}
+
+	if n, err = gomark.DeleteTag(db, "unknown"); err != nil || n != 0 {
+		t.Errorf("Error while deleting an unused tag: got %v (%v)", n, err)
+	}
+
+	if _, err = gomark.RenameTag(db, "misc", " "); err == nil {
+		t.Error("Empty tag accepted")
+	}
+}
+
+func TestTagHierarchy(t *testing.T) {
+
+	b := newTestBookmark(t, "http://example.com/", " Lang/Go//Testing/ ", "tools")
+
+	if tags := b.GetTags(); !b.HasTags("lang/go/testing") || len(tags) != 2 {
+		t.Errorf("Error in the normalization: got %v", tags)
+	}
+
+	for tag, has := range map[string]bool{"lang": true, "LANG/go": true, "lang/go/testing": true, "lang/g": false,
+		"lang/go/testing/unit": false, "go": false, "": false} {
+		if b.HasTags(tag) != has {
+			t.Errorf("Error in HasTags(%q): expected %v", tag, has)
+		}
+	}
+
+	db := gomark.NewDatabase()
+
+	db.Put(b)
+	db.Put(newTestBookmark(t, "http://example.com/a", "lang/go", "lang/python"))
+	db.Put(newTestBookmark(t, "http://example.com/b", "lang", "lang/go/generics"))
+	db.Put(newTestBookmark(t, "http://example.com/c", "langue"))
+
+	tree, err := gomark.TagTree(db)
+	if err != nil {
+		t.Fatalf("Error while listing the tags: %v", err)
+	}
+
+	printed := printTagTree(tree)
+	expected := "lang 1/3 [go 1/3 [generics 1/1 testing 1/1] python 1/1] langue 1/1 tools 1/1"
+	if printed != expected {
+		t.Errorf("Error in the tree: got %s, expected %s", printed, expected)
+	}
+
+	// The subtree moves along
+	if n, err := gomark.RenameTag(db, "lang/go", "golang"); err != nil || n != 3 {
+		t.Errorf("Error while renaming: got %v (%v)", n, err)
+	}
+
+	tree, _ = gomark.TagTree(db)
+	expected = "golang 1/3 [generics 1/1 testing 1/1] lang 1/2 [python 1/1] langue 1/1 tools 1/1"
+	if printed := printTagTree(tree); printed != expected {
+		t.Errorf("Error in the tree after renaming: got %s, expected %s", printed, expected)
+	}
+
+	// Moving a tag under itself
+	if n, err := gomark.RenameTag(db, "lang", "lang/old"); err != nil || n != 2 {
+		t.Errorf("Error while renaming: got %v (%v)", n, err)
+	}
+
+	if b, _ := db.GetByURL("http://example.com/b"); !b.HasTags("lang/old", "golang/generics") || len(b.GetTags()) != 2 {
+		t.Errorf("Error after moving under itself: got %v", b.GetTags())
+	}
+
+	if n, err := gomark.DeleteTag(db, "golang"); err != nil || n != 3 {
+		t.Errorf("Error while deleting: got %v (%v)", n, err)
+	}
+
+	tree, _ = gomark.TagTree(db)
+	expected = "lang 0/2 [old 1/2 [python 1/1]] langue 1/1 tools 1/1"
+	if printed := printTagTree(tree); printed != expected {
+		t.Errorf("Error in the tree after deleting: got %s, expected %s", printed, expected)
+	}
+}
+
+// printTagTree prints the name and the counts of the nodes, the children
+// between brackets
+func printTagTree(nodes []*gomark.TagNode) string {
+
+	var printed []string
+
+	for _, n := range nodes {
+		printed = append(printed, fmt.Sprintf("%s %v/%v", n.Name, n.Count, n.Total))
+		if len(n.Children) > 0 {
+			printed = append(printed, "["+printTagTree(n.Children)+"]")
+		}
+	}
+
+	return strings.Join(printed, " ")
+}
@@ -0,0 +1,165 @@
+package gomark
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Key of the YouTube Data API, YouTube pages use the generic extraction
+// without it
+var YoutubeKey string
+
+// Base URL of the YouTube Data API, changed by tests
+var YoutubeAPIURL = "https://www.googleapis.com/youtube/v3"
+
+// A YouTube resource: a video, a playlist or a channel. Channels are
+// identified by their ID, their @handle or their legacy user name, param is
+// the API parameter holding id.
+type youtubeResource struct {
+	endpoint string
+	param    string
+	id       string
+}
+
+// parseYoutubeURL finds the resource of the URLs of youtube.com, its m.
+// and music. subdomains and youtu.be
+func parseYoutubeURL(u *url.URL) (r youtubeResource, ok bool) {
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	parts := pathParts(u)
+	query := u.Query()
+
+	if host == "youtu.be" {
+		if len(parts) == 0 {
+			return r, false
+		}
+		return youtubeResource{"videos", "id", parts[0]}, true
+	}
+
+	if len(parts) == 0 {
+		return r, false
+	}
+
+	switch {
+
+	case parts[0] == "watch" && len(query.Get("v")) > 0:
+		return youtubeResource{"videos", "id", query.Get("v")}, true
+
+	case (parts[0] == "shorts" || parts[0] == "embed" || parts[0] == "live") && len(parts) > 1:
+		return youtubeResource{"videos", "id", parts[1]}, true
+
+	case parts[0] == "playlist" && len(query.Get("list")) > 0:
+		return youtubeResource{"playlists", "id", query.Get("list")}, true
+
+	// music.youtube.com shows artists as channels
+	case (parts[0] == "channel" || parts[0] == "browse") && len(parts) > 1:
+		return youtubeResource{"channels", "id", parts[1]}, true
+
+	case parts[0] == "user" && len(parts) > 1:
+		return youtubeResource{"channels", "forUsername", parts[1]}, true
+
+	case strings.HasPrefix(parts[0], "@") && len(parts[0]) > 1:
+		return youtubeResource{"channels", "forHandle", parts[0]}, true
+	}
+
+	return r, false
+}
+
+// GetPageYoutube gets the title and description of a video, playlist or
+// channel with the YouTube Data API. It returns ErrNotResolved without
+// YoutubeKey or for the other pages.
+func GetPageYoutube(f *Fetcher, theUrl *url.URL) (page PageInfo, err error) {
+
+	resource, ok := parseYoutubeURL(theUrl)
+	if YoutubeKey == "" || !ok {
+		return page, ErrNotResolved
+	}
+
+	params := url.Values{}
+	params.Set("part", "snippet")
+	params.Set(resource.param, resource.id)
+	params.Set("key", YoutubeKey)
+
+	resp, err := f.Get(fmt.Sprintf("%s/%s?%s", YoutubeAPIURL, resource.endpoint, params.Encode()), "application/json")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	type thumbnail struct {
+		Url string `json:"url"`
+	}
+
+	type snippet struct {
+		Title        string               `json:"title"`
+		Description  string               `json:"description"`
+		ChannelTitle string               `json:"channelTitle"`
+		Thumbnails   map[string]thumbnail `json:"thumbnails"`
+	}
+
+	type item struct {
+		Snippet snippet `json:"snippet"`
+	}
+
+	type ErrorResponse struct {
+		Errors  []map[string]string `json:"errors"`
+		Code    int                 `json:"code"`
+		Message string              `json:"message"`
+	}
+
+	type respStruct struct {
+		Items []item        `json:"items"`
+		Error ErrorResponse `json:"error"`
+	}
+
+	var data respStruct
+
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return
+	}
+
+	if data.Error.Code != 0 {
+		return page, fmt.Errorf("Impossible to retrieve Youtube data: %s", data.Error.Message)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("Impossible to retrieve Youtube data: %s", resp.Status)
+	}
+
+	if len(data.Items) == 0 {
+		return page, fmt.Errorf("Youtube %s not found: %s", resource.endpoint, resource.id)
+	}
+
+	s := data.Items[0].Snippet
+
+	switch resource.endpoint {
+	case "videos":
+		page.Title = fmt.Sprintf("%s: %s", s.ChannelTitle, s.Title)
+	case "playlists":
+		page.Title = fmt.Sprintf("%s: %s (playlist)", s.ChannelTitle, s.Title)
+	default:
+		page.Title = s.Title
+	}
+
+	page.Description = summarize(s.Description)
+	page.Preview.SiteName = "YouTube"
+
+	for _, size := range []string{"maxres", "high", "medium", "default"} {
+		if thumb, ok := s.Thumbnails[size]; ok && len(thumb.Url) > 0 {
+			page.Preview.Image = thumb.Url
+			break
+		}
+	}
+
+	return
+}
+
+// GetTitleYoutube returns the title of a YouTube page with the Data API
+func GetTitleYoutube(theUrl *url.URL) (title string, err error) {
+	page, err := GetPageYoutube(nil, theUrl)
+	return page.Title, err
+}
@@ -0,0 +1,127 @@
+package gomark_test
+
+import (
+	"encoding/json"
+	"github.com/th3osmith/gomark"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestYoutube(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		q := r.URL.Query()
+		if q.Get("key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"code": 403, "message": "Bad key"},
+			})
+			return
+		}
+
+		known := map[string]string{
+			"/videos?id=dQw4w9WgXcQ":                "Never Gonna Give You Up",
+			"/playlists?id=PL590L5WQmH8fJ54F3":      "Best of",
+			"/channels?id=UC38IQsAvIsxxjztdMZQtwHA": "Rick Astley",
+			"/channels?forHandle=@RickAstleyYT":     "Rick Astley",
+			"/channels?forUsername=RickAstleyVEVO":  "Rick Astley VEVO",
+		}
+
+		var key string
+		for _, param := range []string{"id", "forHandle", "forUsername"} {
+			if len(q.Get(param)) > 0 {
+				key = r.URL.Path + "?" + param + "=" + q.Get(param)
+			}
+		}
+
+		items := []interface{}{}
+		if title, ok := known[key]; ok {
+			items = append(items, map[string]interface{}{
+				"snippet": map[string]interface{}{
+					"title":        title,
+					"channelTitle": "Rick Astley",
+					"description":  "The official channel",
+					"thumbnails": map[string]interface{}{
+						"default": map[string]string{"url": "https://i.ytimg.com/default.jpg"},
+						"high":    map[string]string{"url": "https://i.ytimg.com/high.jpg"},
+					},
+				},
+			})
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	}))
+	defer ts.Close()
+
+	defer func(key, api string) {
+		gomark.YoutubeKey, gomark.YoutubeAPIURL = key, api
+	}(gomark.YoutubeKey, gomark.YoutubeAPIURL)
+
+	gomark.YoutubeKey = "secret"
+	gomark.YoutubeAPIURL = ts.URL
+
+	video := "Rick Astley: Never Gonna Give You Up"
+
+	cases := []struct {
+		url   string
+		title string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", video},
+		{"https://youtube.com/watch?v=dQw4w9WgXcQ&list=PL590L5WQmH8fJ54F3", video},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", video},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", video},
+		{"https://youtu.be/dQw4w9WgXcQ?t=42", video},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", video},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", video},
+		{"https://www.youtube.com/playlist?list=PL590L5WQmH8fJ54F3", "Rick Astley: Best of (playlist)"},
+		{"https://www.youtube.com/channel/UC38IQsAvIsxxjztdMZQtwHA", "Rick Astley"},
+		{"https://music.youtube.com/browse/UC38IQsAvIsxxjztdMZQtwHA", "Rick Astley"},
+		{"https://www.youtube.com/@RickAstleyYT/videos", "Rick Astley"},
+		{"https://www.youtube.com/user/RickAstleyVEVO", "Rick Astley VEVO"},
+	}
+
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+
+		page, err := gomark.GetPage(testFetcher(), u)
+		if err != nil {
+			t.Errorf("Error while getting %s: %v", c.url, err)
+			continue
+		}
+
+		if page.Title != c.title {
+			t.Errorf("Error in the title of %s: got %q, expected %q", c.url, page.Title, c.title)
+		}
+
+		if page.Preview.Image != "https://i.ytimg.com/high.jpg" || page.Description != "The official channel" {
+			t.Errorf("Error in the preview of %s: got %v", c.url, page)
+		}
+	}
+
+	// A missing video must not panic
+	u, _ := url.Parse("https://www.youtube.com/watch?v=missing")
+	if _, err := gomark.GetPageYoutube(testFetcher(), u); err == nil || err == gomark.ErrNotResolved {
+		t.Errorf("Error for a missing video: got %v", err)
+	}
+
+	gomark.YoutubeKey = "wrong"
+	if _, err := gomark.GetPageYoutube(testFetcher(), u); err == nil || err == gomark.ErrNotResolved {
+		t.Errorf("Error for a wrong key: got %v", err)
+	}
+
+	// The other pages, or any page without a key, are left to the generic
+	// extraction
+	u, _ = url.Parse("https://www.youtube.com/feed/trending")
+	if _, err := gomark.GetPageYoutube(testFetcher(), u); err != gomark.ErrNotResolved {
+		t.Errorf("Error for an unknown page: got %v", err)
+	}
+
+	gomark.YoutubeKey = ""
+	u, _ = url.Parse("https://youtu.be/dQw4w9WgXcQ")
+	if _, err := gomark.GetPageYoutube(testFetcher(), u); err != gomark.ErrNotResolved {
+		t.Errorf("Error without key: got %v", err)
+	}
+}